@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"github.com/JosueMolinaMorales/orionlang/internal/evaluator"
 	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/optimizer"
 	"github.com/JosueMolinaMorales/orionlang/internal/parser"
 	"github.com/JosueMolinaMorales/orionlang/internal/repl"
 )
@@ -24,6 +26,8 @@ var (
 	filePath       = flag.String("path", ".", "The file path to the file that should be interpreted")
 	runRepl        = flag.Bool("repl", false, "Run REPL for OrionLang")
 	useInterpreter = flag.Bool("interpreter", false, "Execute OrionLang using the interpreter instead of the compiler")
+	optimize       = flag.Bool("optimize", true, "Fold constant expressions, and in the REPL optimize the compiled instructions of OrionLang")
+	dumpInstrs     = flag.Bool("dump-instructions", false, "Print the compiled instructions before and after they are optimized (REPL only)")
 )
 
 func main() {
@@ -39,10 +43,18 @@ func main() {
 
 		fmt.Printf("Feel free to type in commands\n")
 
-		repl.Start(os.Stdin, os.Stdout, *useInterpreter)
+		repl.Start(os.Stdin, os.Stdout, repl.Options{
+			UseInterpreter:   *useInterpreter,
+			Optimize:         *optimize,
+			DumpInstructions: *dumpInstrs,
+		})
 		return
 	}
 
+	if err := checkFileFlags(); err != nil {
+		log.Fatal(err)
+	}
+
 	if !strings.HasSuffix(*filePath, ".or") {
 		log.Fatalf("File %s is not a OrionLang file", *filePath)
 	}
@@ -60,5 +72,24 @@ func main() {
 		return
 	}
 
+	for _, w := range optimizer.EliminateDeadCode(program) {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", *filePath, w)
+	}
+
+	if *optimize {
+		optimizer.Fold(program)
+	}
+
 	evaluator.Eval(program, object.NewEnvironment())
 }
+
+// checkFileFlags returns an error if a flag that only applies to the REPL is set when
+// executing a file. Files are executed by the interpreter, so there are no compiled
+// instructions to dump.
+func checkFileFlags() error {
+	if *dumpInstrs {
+		return errors.New("-dump-instructions only applies to the REPL: pass -repl as well")
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestCheckFileFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-path", "main.or"}, ""},
+		{[]string{"-path", "main.or", "-optimize=false"}, ""},
+		{[]string{"-path", "main.or", "-dump-instructions"}, "-dump-instructions only applies to the REPL: pass -repl as well"},
+		{[]string{"-path", "main.or", "-dump-instructions=false"}, ""},
+	}
+
+	for _, tt := range tests {
+		err := flag.CommandLine.Parse(tt.args)
+		if err != nil {
+			t.Fatalf("parsing %v failed: %s", tt.args, err)
+		}
+
+		err = checkFileFlags()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %v: %s", tt.args, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%v", tt.args, tt.expected, err)
+		}
+	}
+}
@@ -31,11 +31,13 @@ type Expression interface {
 	expressionNode()
 }
 
-// LetStatement represents a let statment node in the AST
+// LetStatement represents a let statment node in the AST.
+// A destructuring let, e.g. let [a, ...rest] = xs, has a Pattern instead of a Name.
 type LetStatement struct {
-	Token token.Token // the token.LET Token
-	Name  *Identifier
-	Value Expression
+	Token   token.Token // the token.LET Token
+	Name    *Identifier
+	Pattern Pattern
+	Value   Expression
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -47,7 +49,11 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -360,6 +366,7 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // The '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // The keys of Pairs in source order
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -368,8 +375,97 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Pattern represents a pattern node in the AST. Patterns are tested against a value
+// and bind the names they contain when the value matches.
+type Pattern interface {
+	// Node represents a node in the AST
+	Node
+	// patternNode represents a pattern node in the AST
+	patternNode()
+}
+
+// WildcardPattern matches any value without binding it, e.g. _
+type WildcardPattern struct {
+	Token token.Token // The '_' token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// BindingPattern matches any value and binds it to Name
+type BindingPattern struct {
+	Token token.Token // The token.IDENT token
+	Name  *Identifier
+}
+
+func (bp *BindingPattern) patternNode()         {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Token.Literal }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+
+// LiteralPattern matches values equal to an integer, string or boolean literal
+type LiteralPattern struct {
+	Token token.Token // The first token of the literal
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Token.Literal }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// ArrayPattern matches arrays element by element, e.g. [head, ...tail].
+// Without a Rest pattern the array must have exactly len(Elements) elements.
+type ArrayPattern struct {
+	Token    token.Token // The '[' token
+	Elements []Pattern
+	Rest     Pattern // nil when the pattern has no rest element
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// HashPattern matches hashes that contain every key in Keys and whose values
+// match the pattern at the same position in Values, e.g. {"id": id}
+type HashPattern struct {
+	Token  token.Token // The '{' token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for i, key := range hp.Keys {
+		pairs = append(pairs, key.String()+": "+hp.Values[i].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -377,3 +473,120 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// MatchArm is a single `pattern if guard => body` arm of a match expression
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil when the arm has no guard
+	Body    Expression
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// MatchExpression dispatches on the shape of Subject, evaluating the body of the
+// first arm whose pattern matches and whose guard is truthy
+type MatchExpression struct {
+	Token   token.Token // The 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// StructStatement declares a record type with a fixed set of fields, e.g. struct Point { x, y }
+type StructStatement struct {
+	Token  token.Token // The 'struct' token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (ss *StructStatement) statementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range ss.Fields {
+		fields = append(fields, f.String())
+	}
+	out.WriteString("struct ")
+	out.WriteString(ss.Name.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// FieldExpression accesses a named field of a struct, e.g. p.x, or a string key of a
+// hash, e.g. h.key. When it is the function of a CallExpression the call is a method
+// call and the value of Left is bound to self.
+type FieldExpression struct {
+	Token token.Token // The '.' token
+	Left  Expression
+	Field *Identifier
+}
+
+func (fe *FieldExpression) expressionNode()      {}
+func (fe *FieldExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FieldExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(fe.Left.String())
+	out.WriteString(".")
+	out.WriteString(fe.Field.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// AssignExpression assigns a value to a field, e.g. p.x = 5.
+// It evaluates to the assigned value.
+type AssignExpression struct {
+	Token  token.Token // The '=' token
+	Target Expression
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
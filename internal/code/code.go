@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 // Instructions represents a sequence of bytes that define a set of instructions.
@@ -18,16 +19,20 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		instruction, err := ReadInstruction(ins[i:])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		def := definitions[instruction.Op]
+		if instruction.Wide {
+			fmt.Fprintf(&out, "%04d OpWide %s\n", i, ins.fmtInstruction(def, instruction.Operands))
+		} else {
+			fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, instruction.Operands))
+		}
 
-		i += 1 + read
+		i += instruction.Width
 	}
 
 	return out.String()
@@ -47,6 +52,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
@@ -125,8 +132,68 @@ const (
 	OpSetLocal
 	// OpGetBuiltin represents retrieving a builtin function to call
 	OpGetBuiltin
+	// OpMatchLiteral pops a literal and the value being matched off the stack and
+	// pushes true if both have the same type and value
+	OpMatchLiteral
+	// OpMatchArray pops the value being matched and pushes true if it is an array that fits
+	// the pattern. It has 2 operands: the number of element patterns and 1 if the pattern
+	// has a rest element, in which case the array may be longer
+	OpMatchArray
+	// OpMatchHash pops the value being matched and pushes true if it is a hash
+	OpMatchHash
+	// OpHasKey pops a key and a hash off the stack and pushes true if the hash contains the key
+	OpHasKey
+	// OpArrayRest pops an array and pushes a new array holding every element from the
+	// index given by its operand onwards
+	OpArrayRest
+	// OpGetField pops a struct and pushes the value of one of its fields. Its operand is
+	// the index of the FieldName constant naming the field
+	OpGetField
+	// OpSetField pops a value and a struct, stores the value in one of the struct's fields
+	// and pushes the value back. Its operand is the index of the FieldName constant naming
+	// the field
+	OpSetField
+	// OpCallMethod calls the function stored in a field of the receiver sitting below the
+	// arguments on the stack, binding the receiver to self. It has 2 operands: the number of
+	// arguments and the index of the FieldName constant naming the field
+	OpCallMethod
+	// OpGetSelf pushes the receiver of the current method call, or null outside of one
+	OpGetSelf
+	// OpTailCall is an OpCall whose result is returned straight away by the calling function.
+	// Calls to compiled functions reuse the caller's frame instead of pushing a new one
+	OpTailCall
+	// OpMatchTable pops the subject of a match and jumps to the target of the OpCase that
+	// a MatchTable selects for it. It has 2 operands: the index of the MatchTable constant
+	// and the number of OpCase instructions following it, the last of which is taken
+	// when the table has no case for the subject
+	OpMatchTable
+	// OpCase is an entry of the table following an OpMatchTable. Its operand is the
+	// position the entry jumps to. The entries of a table are all narrow or all wide, so
+	// the VM can find an entry without reading the ones before it
+	OpCase
+
+	// Superinstructions do the work of a common sequence of instructions in one dispatch.
+
+	// OpGetLocal0 to OpGetLocal3 are OpGetLocal with the local index 0 to 3 built in
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+	// OpAddConst is `OpConstant; OpAdd`. It adds the constant its operand indexes to the
+	// value on top of the stack
+	OpAddConst
+	// OpJumpIfNotGreater is `OpGreaterThan; OpJumpNotTruthy`. It pops two values and jumps
+	// to its operand unless the lower one is greater than the top one
+	OpJumpIfNotGreater
+
+	// OpWide is a prefix that widens every operand of the instruction following it to four
+	// bytes, for operands that do not fit their usual width
+	OpWide
 )
 
+// WideOperandWidth is the width of every operand of an instruction prefixed by OpWide
+const WideOperandWidth = 4
+
 type Definition struct {
 	// Name helps to make an Opcode readable
 	Name string
@@ -165,6 +232,27 @@ var definitions = map[Opcode]*Definition{
 	OpSetLocal:      {"OpSetLocal", []int{1}},
 	OpGetLocal:      {"OpGetLocal", []int{1}},
 	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
+	OpMatchLiteral:  {"OpMatchLiteral", []int{}},
+	OpMatchArray:    {"OpMatchArray", []int{2, 1}},
+	OpMatchHash:     {"OpMatchHash", []int{}},
+	OpHasKey:        {"OpHasKey", []int{}},
+	OpArrayRest:     {"OpArrayRest", []int{2}},
+	OpGetField:      {"OpGetField", []int{2}},
+	OpSetField:      {"OpSetField", []int{2}},
+	OpCallMethod:    {"OpCallMethod", []int{1, 2}},
+	OpGetSelf:       {"OpGetSelf", []int{}},
+	OpTailCall:      {"OpTailCall", []int{1}},
+	OpMatchTable:    {"OpMatchTable", []int{2, 2}},
+	OpCase:          {"OpCase", []int{2}},
+
+	OpGetLocal0:        {"OpGetLocal0", []int{}},
+	OpGetLocal1:        {"OpGetLocal1", []int{}},
+	OpGetLocal2:        {"OpGetLocal2", []int{}},
+	OpGetLocal3:        {"OpGetLocal3", []int{}},
+	OpAddConst:         {"OpAddConst", []int{2}},
+	OpJumpIfNotGreater: {"OpJumpIfNotGreater", []int{2}},
+
+	OpWide: {"OpWide", []int{}},
 }
 
 // Lookup looksup an opcode and returns its definition if found. otherwise, returns an error.
@@ -176,6 +264,111 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// IsJump reports whether op is a jump, whose first operand is the position it jumps to
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpIfNotGreater, OpCase:
+		return true
+	default:
+		return false
+	}
+}
+
+// Instruction is a single decoded instruction
+type Instruction struct {
+	Op       Opcode
+	Operands []int
+	// Wide reports whether the instruction is prefixed by OpWide
+	Wide bool
+	// Width is the number of bytes the instruction takes up, including any prefix
+	Width int
+}
+
+// ReadInstruction decodes the instruction at the start of ins. An OpWide prefix is
+// decoded together with the instruction it widens.
+func ReadInstruction(ins Instructions) (Instruction, error) {
+	def, err := Lookup(ins[0])
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	if Opcode(ins[0]) != OpWide {
+		operands, read := ReadOperands(def, ins[1:])
+		return Instruction{Op: Opcode(ins[0]), Operands: operands, Width: 1 + read}, nil
+	}
+
+	if len(ins) < 2 {
+		return Instruction{}, fmt.Errorf("OpWide is not followed by an instruction")
+	}
+	def, err = Lookup(ins[1])
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	operands, read := ReadOperands(widen(def), ins[2:])
+	return Instruction{Op: Opcode(ins[1]), Operands: operands, Wide: true, Width: 2 + read}, nil
+}
+
+// Fits reports whether every operand fits the width the definition of op gives it
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+
+	for i, o := range operands {
+		if o < 0 || o > maxOperand(def.OperandWidths[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FitsWide reports whether every operand fits the width of a wide operand
+func FitsWide(operands ...int) bool {
+	for _, o := range operands {
+		if o < 0 || o > maxOperand(WideOperandWidth) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maxOperand returns the largest operand that can be encoded in width bytes
+func maxOperand(width int) int {
+	switch width {
+	case 1:
+		return math.MaxUint8
+	case 2:
+		return math.MaxUint16
+	default:
+		return math.MaxUint32
+	}
+}
+
+// MakeWide creates an instruction prefixed by OpWide, with every operand encoded in
+// four bytes
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	return append([]byte{byte(OpWide)}, makeInstruction(widen(def), op, operands)...)
+}
+
+// widen returns def with every operand widened to four bytes
+func widen(def *Definition) *Definition {
+	widths := make([]int, len(def.OperandWidths))
+	for i := range widths {
+		widths[i] = WideOperandWidth
+	}
+
+	return &Definition{Name: def.Name, OperandWidths: widths}
+}
+
 // Make creates an instruction byte slice based on the given opcode and operands.
 // It returns the instruction byte slice.
 // If the opcode is not found in the definitions, it returns an empty byte slice.
@@ -183,6 +376,7 @@ func Lookup(op byte) (*Definition, error) {
 // The instruction byte slice is created with the opcode as the first byte.
 // For each operand, the width of the operand is determined and the value is converted to BigEndian byte.
 // The offset is increased based on the width of the operand.
+// Operands that do not fit their width are truncated; use Fits to check them first.
 func Make(op Opcode, operands ...int) []byte {
 	// Find the opcode definition
 	def, ok := definitions[op]
@@ -190,6 +384,11 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
+	return makeInstruction(def, op, operands)
+}
+
+// makeInstruction encodes op with operands laid out as described by def
+func makeInstruction(def *Definition, op Opcode, operands []int) []byte {
 	// Get the length of the instruction by adding the length of each operand
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
@@ -207,6 +406,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -226,6 +427,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUInt32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUInt16(ins[offset:]))
 		case 1:
@@ -237,6 +440,12 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+// ReadUInt32 reads a uint32 value from the given byte slice.
+// It assumes that the byte slice is in big-endian byte order.
+func ReadUInt32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 // ReadUInt16 reads a uint16 value from the given byte slice.
 // It assumes that the byte slice is in big-endian byte order.
 func ReadUInt16(ins Instructions) uint16 {
@@ -23,6 +23,11 @@ func TestMake(t *testing.T) {
 			[]int{255},
 			[]byte{byte(OpGetLocal), 255},
 		},
+		{
+			OpMatchArray,
+			[]int{65534, 1},
+			[]byte{byte(OpMatchArray), 255, 254, 1},
+		},
 	}
 
 	for _, tt := range tests {
@@ -45,11 +50,17 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpMatchArray, 2, 1),
+		MakeWide(OpConstant, 65536),
+		MakeWide(OpCallMethod, 256, 3),
 	}
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
+0009 OpMatchArray 2 1
+0013 OpWide OpConstant 65536
+0019 OpWide OpCallMethod 256 3
 `
 
 	concatted := Instructions{}
@@ -70,6 +81,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpMatchArray, []int{65535, 1}, 3},
 	}
 
 	for _, tt := range tests {
@@ -93,3 +105,88 @@ func TestReadOperands(t *testing.T) {
 
 	}
 }
+
+func TestMakeWide(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{
+			OpConstant,
+			[]int{65536},
+			[]byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0},
+		},
+		{
+			OpMatchArray,
+			[]int{70000, 1},
+			[]byte{byte(OpWide), byte(OpMatchArray), 0, 1, 17, 112, 0, 0, 0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		instruction := MakeWide(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("wrong instruction. want=%v, got=%v", tt.expected, instruction)
+		}
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected bool
+	}{
+		{OpConstant, []int{65535}, true},
+		{OpConstant, []int{65536}, false},
+		{OpGetLocal, []int{255}, true},
+		{OpGetLocal, []int{256}, false},
+		{OpCallMethod, []int{255, 65535}, true},
+		{OpCallMethod, []int{256, 0}, false},
+		{OpConstant, []int{-1}, false},
+	}
+
+	for _, tt := range tests {
+		if fits := Fits(tt.op, tt.operands...); fits != tt.expected {
+			t.Errorf("wrong result for %d %v. want=%t, got=%t", tt.op, tt.operands, tt.expected, fits)
+		}
+	}
+}
+
+func TestReadInstruction(t *testing.T) {
+	tests := []struct {
+		instruction []byte
+		expected    Instruction
+	}{
+		{
+			Make(OpGetLocal, 255),
+			Instruction{Op: OpGetLocal, Operands: []int{255}, Width: 2},
+		},
+		{
+			MakeWide(OpGetLocal, 256),
+			Instruction{Op: OpGetLocal, Operands: []int{256}, Wide: true, Width: 6},
+		},
+		{
+			MakeWide(OpCallMethod, 300, 70000),
+			Instruction{Op: OpCallMethod, Operands: []int{300, 70000}, Wide: true, Width: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		instruction, err := ReadInstruction(tt.instruction)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if instruction.Op != tt.expected.Op || instruction.Wide != tt.expected.Wide || instruction.Width != tt.expected.Width {
+			t.Errorf("wrong instruction. want=%+v, got=%+v", tt.expected, instruction)
+		}
+
+		for i, want := range tt.expected.Operands {
+			if instruction.Operands[i] != want {
+				t.Errorf("operand %d wrong. want=%d, got=%d", i, want, instruction.Operands[i])
+			}
+		}
+	}
+}
@@ -2,7 +2,7 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
+	"math"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/ast"
 	"github.com/JosueMolinaMorales/orionlang/internal/code"
@@ -23,12 +23,38 @@ type EmittedInstruction struct {
 	Position int
 }
 
+// Names of the hidden bindings the compiler introduces. They can never collide with
+// a user binding since `$` is not valid in identifiers.
+const (
+	// matchSubjectName is the name the value of a match expression is bound to
+	matchSubjectName = "$match"
+	// letValueName is the name the value of a destructuring let is bound to
+	letValueName = "$let"
+)
+
+// receiverName is the predeclared name of the receiver of a method call
+const receiverName = "self"
+
 // Compiler compiles an AST to bytecode using the `compile()` method.
 type Compiler struct {
-	constants   []object.Object
+	constants []object.Object
+	// integerConstants and stringConstants map the value of every integer and string
+	// constant to its index, so each value is only added to the constant pool once
+	integerConstants map[int64]int
+	stringConstants  map[string]int
+
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// superinstructions selects specialized opcodes for common instruction sequences
+	superinstructions bool
+
+	// operandErr records the first instruction whose operands could not be encoded
+	operandErr error
+
+	// builtins are the builtins identifiers that are not bound may refer to
+	builtins *object.Registry
 }
 
 // New creates a pointer to a Compiler object
@@ -39,66 +65,112 @@ func New() *Compiler {
 		previousInstruction: EmittedInstruction{},
 	}
 
-	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+		constants:        []object.Object{},
+		integerConstants: map[int64]int{},
+		stringConstants:  map[string]int{},
+		symbolTable:      NewSymbolTable(),
+		builtins:         object.NewStandardRegistry(),
+		scopes:           []CompilationScope{mainScope},
+		scopeIndex:       0,
+
+		superinstructions: true,
 	}
 }
 
 // NewWithState creates a new Compiler instance with the given symbol table and constants.
 // It initializes the symbol table and constants of the compiler and returns the created instance.
+// Integer and string constants that are already in constants are reused rather than added again.
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
 
+	for i, c := range constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			compiler.integerConstants[c.Value] = i
+		case *object.String:
+			compiler.stringConstants[c.Value] = i
+		}
+	}
+
 	return compiler
 }
 
+// SetBuiltins sets the builtins the compiled program may call. Builtins that are used are
+// recorded by name in the bytecode, and the VM that runs it looks them up in its own
+// registry. It must be called before Compile.
+func (c *Compiler) SetBuiltins(builtins *object.Registry) {
+	c.builtins = builtins
+}
+
+// SetSuperinstructions enables or disables selecting superinstructions, specialized
+// opcodes such as OpAddConst that do the work of a common instruction sequence in one
+// dispatch. They are enabled by default.
+func (c *Compiler) SetSuperinstructions(enabled bool) {
+	c.superinstructions = enabled
+}
+
 // Compile compiles the given AST node.
 // It recursively traverses the AST and emits bytecode instructions based on the node type.
-// Returns an error if compilation fails.
+// Returns an error if compilation fails, including when a program exceeds a limit of the
+// bytecode, such as the number of global bindings.
 func (c *Compiler) Compile(node ast.Node) error {
+	err := c.compile(node)
+	if err != nil {
+		return err
+	}
+
+	return c.operandErr
+}
+
+// compile compiles node without checking for operands that could not be encoded
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compile(s)
 			if err != nil {
 				return err
 			}
 		}
 
 	case *ast.ExpressionStatement:
-		err := c.Compile(node.Expression)
+		err := c.compile(node.Expression)
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
+		if node.Operator == "+" && c.superinstructions {
+			if literal, ok := literalConstant(node.Right); ok {
+				err := c.compile(node.Left)
+				if err != nil {
+					return err
+				}
+				c.emit(code.OpAddConst, c.addConstant(literal))
+				return nil
+			}
+		}
+
 		if node.Operator == "<" {
-			err := c.Compile(node.Right)
+			err := c.compile(node.Right)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(node.Left)
+			err = c.compile(node.Left)
 			if err != nil {
 				return err
 			}
 			c.emit(code.OpGreaterThan)
 			return nil
 		}
-		err := c.Compile(node.Left)
+		err := c.compile(node.Left)
 		if err != nil {
 			return err
 		}
-		err = c.Compile(node.Right)
+		err = c.compile(node.Right)
 		if err != nil {
 			return err
 		}
@@ -122,7 +194,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
-		err := c.Compile(node.Right)
+		err := c.compile(node.Right)
 		if err != nil {
 			return err
 		}
@@ -136,15 +208,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator: %s", node.Operator)
 		}
 	case *ast.IfExpression:
-		err := c.Compile(node.Condition)
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		jumpNotTruthyPos, err := c.compileCondition(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		// Emit an `OpJumpNotTruthy` with a bogus value
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
-
-		err = c.Compile(node.Consequence)
+		err = c.compile(node.Consequence)
 		if err != nil {
 			return err
 		}
@@ -162,7 +232,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compile(node.Alternative)
 			if err != nil {
 				return err
 			}
@@ -173,14 +243,102 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.MatchExpression:
+		err := c.compile(node.Subject)
+		if err != nil {
+			return err
+		}
+
+		table, numTableArms := matchTable(node.Arms)
+		arms := node.Arms[numTableArms:]
+
+		// The subject is kept in a hidden binding so each arm that is not in the table
+		// can load it again
+		var subject Symbol
+		if len(arms) > 0 {
+			subject = c.symbolTable.DefineScratch(matchSubjectName)
+			c.storeSymbol(subject)
+			if numTableArms > 0 {
+				c.loadSymbol(subject)
+			}
+		}
+
+		endJumps := []int{}
+		if numTableArms > 0 {
+			// The leading literal arms are dispatched through a table with an entry per
+			// arm and a last entry for a subject none of them matches
+			c.emit(code.OpMatchTable, c.addConstant(table), numTableArms+1)
+			cases := make([]int, numTableArms+1)
+			for i := range cases {
+				cases[i] = c.emit(code.OpCase, 9999)
+			}
+
+			for i, arm := range node.Arms[:numTableArms] {
+				c.changeOperand(cases[i], len(c.currentInstructions()))
+				err := c.compile(arm.Body)
+				if err != nil {
+					return err
+				}
+				endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+			}
+			c.changeOperand(cases[numTableArms], len(c.currentInstructions()))
+		}
+
+		// The other arms are tested one after the other, since guards and binding
+		// patterns have to be tried in order
+		for _, arm := range arms {
+			// The names an arm binds are only visible in its guard and body
+			c.symbolTable.EnterBlock()
+			failJumps, err := c.compilePattern(arm.Pattern, func() { c.loadSymbol(subject) })
+			if err != nil {
+				return err
+			}
+
+			if arm.Guard != nil {
+				jumpPos, err := c.compileCondition(arm.Guard)
+				if err != nil {
+					return err
+				}
+				failJumps = append(failJumps, jumpPos)
+			}
+
+			err = c.compile(arm.Body)
+			if err != nil {
+				return err
+			}
+			c.symbolTable.LeaveBlock()
+			endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+			// A failed test falls through to the next arm
+			nextArmPos := len(c.currentInstructions())
+			for _, pos := range failJumps {
+				c.changeOperand(pos, nextArmPos)
+			}
+		}
+
+		if len(arms) > 0 {
+			c.symbolTable.ReleaseScratch(subject)
+		}
+
+		// No arm matched
+		c.emit(code.OpNull)
+
+		afterMatchPos := len(c.currentInstructions())
+		for _, pos := range endJumps {
+			c.changeOperand(pos, afterMatchPos)
+		}
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+		if field, ok := node.Function.(*ast.FieldExpression); ok {
+			return c.compileMethodCall(field, node.Arguments)
+		}
+
+		err := c.compile(node.Function)
 		if err != nil {
 			return err
 		}
 
 		for _, a := range node.Arguments {
-			err := c.Compile(a)
+			err := c.compile(a)
 			if err != nil {
 				return err
 			}
@@ -189,29 +347,83 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpCall, len(node.Arguments))
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compile(s)
 			if err != nil {
 				return err
 			}
 		}
 	case *ast.LetStatement:
-		err := c.Compile(node.Value)
+		// A global function is defined before its body is compiled so it can call itself.
+		// Functions can not reach the locals of the function they are defined in, so a
+		// local function is only defined afterwards and calling itself is a compile error.
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok && node.Pattern == nil && c.scopeIndex == 0 {
+			symbol := c.symbolTable.Define(node.Name.Value)
+			err := c.compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.storeSymbol(symbol)
+			return nil
+		}
+
+		err := c.compile(node.Value)
 		if err != nil {
 			return err
 		}
+		if node.Pattern != nil {
+			// The value is kept in a hidden binding so each element can be loaded from it
+			value := c.symbolTable.DefineScratch(letValueName)
+			c.storeSymbol(value)
+
+			err := c.compileDestructuring(node.Pattern, func() { c.loadSymbol(value) })
+			c.symbolTable.ReleaseScratch(value)
+			return err
+		}
 		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range node.Fields {
+			fields = append(fields, f.Value)
+		}
+		def := &object.StructType{Name: node.Name.Value, Fields: fields}
+		c.emit(code.OpConstant, c.addConstant(def))
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.storeSymbol(symbol)
+	case *ast.FieldExpression:
+		err := c.compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		name := &object.FieldName{Name: node.Field.Value}
+		c.emit(code.OpGetField, c.addConstant(name))
+	case *ast.AssignExpression:
+		target, ok := node.Target.(*ast.FieldExpression)
+		if !ok {
+			return fmt.Errorf("cannot assign to %s", node.Target.String())
 		}
+
+		err := c.compile(target.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		name := &object.FieldName{Name: target.Field.Value}
+		c.emit(code.OpSetField, c.addConstant(name))
 	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
+		err := c.compile(node.Left)
 		if err != nil {
 			return err
 		}
 
-		err = c.Compile(node.Index)
+		err = c.compile(node.Index)
 		if err != nil {
 			return err
 		}
@@ -224,7 +436,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.symbolTable.Define(p.Value)
 		}
 
-		err := c.Compile(node.Body)
+		err := c.compile(node.Body)
 		if err != nil {
 			return err
 		}
@@ -235,6 +447,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if !c.lastInstructionIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
+		c.markTailCalls()
 
 		numLocals := c.symbolTable.numDefinitions
 		instructions := c.leaveScope()
@@ -246,27 +459,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpConstant, c.addConstant(compiledFn))
 	case *ast.ReturnStatement:
-		err := c.Compile(node.ReturnValue)
+		err := c.compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 
 		c.emit(code.OpReturnValue)
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		for _, k := range node.Keys {
+			err := c.compile(k)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(node.Pairs[k])
+			err = c.compile(node.Pairs[k])
 			if err != nil {
 				return err
 			}
@@ -274,7 +479,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
-			err := c.Compile(el)
+			err := c.compile(el)
 			if err != nil {
 				return err
 			}
@@ -287,9 +492,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
 	case *ast.Identifier:
+		if node.Value == receiverName {
+			// self is predeclared in every function as the receiver of the call, unless
+			// the function binds the name itself
+			if symbol, ok := c.symbolTable.ResolveLocal(node.Value); ok {
+				c.loadSymbol(symbol)
+			} else {
+				c.emit(code.OpGetSelf)
+			}
+			return nil
+		}
+
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			if _, isBuiltin := c.builtins.Lookup(node.Value); !isBuiltin {
+				return fmt.Errorf("undefined variable %s", node.Value)
+			}
+			symbol = c.symbolTable.UseBuiltin(node.Value)
 		}
 
 		c.loadSymbol(symbol)
@@ -304,17 +523,357 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileCondition emits condition followed by a jump with a bogus target that is taken
+// when the condition is not truthy, and returns the position of the jump. A comparison
+// with > or < is fused with the jump into an OpJumpIfNotGreater.
+func (c *Compiler) compileCondition(condition ast.Expression) (int, error) {
+	infix, ok := condition.(*ast.InfixExpression)
+	if !ok || !c.superinstructions || (infix.Operator != ">" && infix.Operator != "<") {
+		err := c.compile(condition)
+		if err != nil {
+			return 0, err
+		}
+		return c.emit(code.OpJumpNotTruthy, 9999), nil
+	}
+
+	// a < b is compiled as b > a
+	left, right := infix.Left, infix.Right
+	if infix.Operator == "<" {
+		left, right = right, left
+	}
+
+	err := c.compile(left)
+	if err != nil {
+		return 0, err
+	}
+	err = c.compile(right)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.emit(code.OpJumpIfNotGreater, 9999), nil
+}
+
+// literalConstant returns the constant an integer or string literal compiles to
+func literalConstant(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	default:
+		return nil, false
+	}
+}
+
+// compileMethodCall emits a call of the function stored in field.Field of field.Left.
+// The receiver takes the place of the callee on the stack, and the VM swaps in the
+// method once the arguments have been evaluated.
+func (c *Compiler) compileMethodCall(field *ast.FieldExpression, arguments []ast.Expression) error {
+	err := c.compile(field.Left)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range arguments {
+		err := c.compile(a)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := &object.FieldName{Name: field.Field.Value}
+	c.emit(code.OpCallMethod, len(arguments), c.addConstant(name))
+
+	return nil
+}
+
+// matchTable returns the table dispatching on the patterns of the leading arms that
+// test for an integer or string literal without a guard, and the number of those arms.
+// A value that several of the arms test for selects the first of them.
+func matchTable(arms []*ast.MatchArm) (*object.MatchTable, int) {
+	table := &object.MatchTable{Integers: map[int64]int{}, Strings: map[string]int{}}
+	for i, arm := range arms {
+		pattern, ok := arm.Pattern.(*ast.LiteralPattern)
+		if !ok || arm.Guard != nil {
+			return table, i
+		}
+
+		switch value := pattern.Value.(type) {
+		case *ast.IntegerLiteral:
+			if _, ok := table.Integers[value.Value]; !ok {
+				table.Integers[value.Value] = i
+			}
+		case *ast.PrefixExpression:
+			literal, ok := value.Right.(*ast.IntegerLiteral)
+			if !ok || value.Operator != "-" {
+				return table, i
+			}
+			if _, ok := table.Integers[-literal.Value]; !ok {
+				table.Integers[-literal.Value] = i
+			}
+		case *ast.StringLiteral:
+			if _, ok := table.Strings[value.Value]; !ok {
+				table.Strings[value.Value] = i
+			}
+		default:
+			return table, i
+		}
+	}
+
+	return table, len(arms)
+}
+
+// compilePattern emits the tests and bindings for pattern. load emits the instructions
+// that push the value being matched. It returns the positions of the jumps taken when
+// the value does not match, which the caller must point at the next arm.
+func (c *Compiler) compilePattern(pattern ast.Pattern, load func()) ([]int, error) {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil, nil
+	case *ast.BindingPattern:
+		load()
+		symbol := c.symbolTable.Define(pattern.Name.Value)
+		c.storeSymbol(symbol)
+		return nil, nil
+	case *ast.LiteralPattern:
+		load()
+		err := c.compile(pattern.Value)
+		if err != nil {
+			return nil, err
+		}
+		c.emit(code.OpMatchLiteral)
+		return []int{c.emit(code.OpJumpNotTruthy, 9999)}, nil
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pattern.Rest != nil {
+			hasRest = 1
+		}
+
+		load()
+		c.emit(code.OpMatchArray, len(pattern.Elements), hasRest)
+		failJumps := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+
+		for i, el := range pattern.Elements {
+			index := &object.Integer{Value: int64(i)}
+			jumps, err := c.compilePattern(el, func() {
+				load()
+				c.emit(code.OpConstant, c.addConstant(index))
+				c.emit(code.OpIndex)
+			})
+			if err != nil {
+				return nil, err
+			}
+			failJumps = append(failJumps, jumps...)
+		}
+
+		if pattern.Rest != nil {
+			jumps, err := c.compilePattern(pattern.Rest, func() {
+				load()
+				c.emit(code.OpArrayRest, len(pattern.Elements))
+			})
+			if err != nil {
+				return nil, err
+			}
+			failJumps = append(failJumps, jumps...)
+		}
+
+		return failJumps, nil
+	case *ast.HashPattern:
+		load()
+		c.emit(code.OpMatchHash)
+		failJumps := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+
+		for i, key := range pattern.Keys {
+			load()
+			err := c.compile(key)
+			if err != nil {
+				return nil, err
+			}
+			c.emit(code.OpHasKey)
+			failJumps = append(failJumps, c.emit(code.OpJumpNotTruthy, 9999))
+
+			var keyErr error
+			jumps, err := c.compilePattern(pattern.Values[i], func() {
+				load()
+				keyErr = c.compile(key)
+				c.emit(code.OpIndex)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if keyErr != nil {
+				return nil, keyErr
+			}
+			failJumps = append(failJumps, jumps...)
+		}
+
+		return failJumps, nil
+	default:
+		return nil, fmt.Errorf("unknown pattern %T", pattern)
+	}
+}
+
+// compileDestructuring emits the index loads that bind each name in pattern.
+// load emits the instructions that push the value being destructured.
+func (c *Compiler) compileDestructuring(pattern ast.Pattern, load func()) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.BindingPattern:
+		load()
+		symbol := c.symbolTable.Define(pattern.Name.Value)
+		c.storeSymbol(symbol)
+		return nil
+	case *ast.ArrayPattern:
+		for i, el := range pattern.Elements {
+			index := &object.Integer{Value: int64(i)}
+			loadElement, release := c.loadOrEmpty(el, func() {
+				load()
+				c.emit(code.OpConstant, c.addConstant(index))
+				c.emit(code.OpIndex)
+			})
+			err := c.compileDestructuring(el, loadElement)
+			release()
+			if err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			return c.compileDestructuring(pattern.Rest, func() {
+				load()
+				c.emit(code.OpArrayRest, len(pattern.Elements))
+			})
+		}
+
+		return nil
+	case *ast.HashPattern:
+		for i, key := range pattern.Keys {
+			var keyErr error
+			loadValue, release := c.loadOrEmpty(pattern.Values[i], func() {
+				load()
+				keyErr = c.compile(key)
+				c.emit(code.OpIndex)
+			})
+			err := c.compileDestructuring(pattern.Values[i], loadValue)
+			release()
+			if err != nil {
+				return err
+			}
+			if keyErr != nil {
+				return keyErr
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported pattern in let statement: %s", pattern.String())
+	}
+}
+
+// loadOrEmpty returns load, unless pattern is a nested array or hash pattern. Then it
+// emits load once, keeping the value in a hidden binding, and returns a load of that
+// binding. A missing value is replaced by an empty array or hash, so that every name in
+// the pattern binds null rather than failing to index null. The returned release frees
+// the hidden binding, and must be called once the pattern is compiled.
+func (c *Compiler) loadOrEmpty(pattern ast.Pattern, load func()) (func(), func()) {
+	var empty code.Opcode
+	switch pattern.(type) {
+	case *ast.ArrayPattern:
+		empty = code.OpArray
+	case *ast.HashPattern:
+		empty = code.OpHash
+	default:
+		return load, func() {}
+	}
+
+	load()
+	value := c.symbolTable.DefineScratch(letValueName)
+	c.storeSymbol(value)
+
+	c.loadSymbol(value)
+	c.emit(code.OpNull)
+	c.emit(code.OpEqual)
+	jumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+	c.emit(empty, 0)
+	c.storeSymbol(value)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return func() { c.loadSymbol(value) }, func() { c.symbolTable.ReleaseScratch(value) }
+}
+
+// storeSymbol emits the instruction that pops the top of the stack into the given symbol
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
 		c.emit(code.OpGetGlobal, s.Index)
 	case LocalScope:
+		if c.superinstructions && s.Index <= 3 {
+			c.emit(code.OpGetLocal0 + code.Opcode(s.Index))
+			return
+		}
 		c.emit(code.OpGetLocal, s.Index)
 	case BuiltinScope:
 		c.emit(code.OpGetBuiltin, s.Index)
 	}
 }
 
+// markTailCalls turns every OpCall in the current scope whose result is returned straight
+// away into an OpTailCall. A call is in tail position when the instruction after it is an
+// OpReturnValue, or an OpJump that leads to one, as at the end of an if or match arm.
+func (c *Compiler) markTailCalls() {
+	ins := c.currentInstructions()
+	for pos := 0; pos < len(ins); {
+		instruction, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			return
+		}
+		next := pos + instruction.Width
+
+		if instruction.Op == code.OpCall && returnsAt(ins, next) {
+			opPos := pos
+			if instruction.Wide {
+				opPos++
+			}
+			ins[opPos] = byte(code.OpTailCall)
+		}
+		pos = next
+	}
+}
+
+// returnsAt reports whether execution starting at pos returns the value on top of the
+// stack without doing anything else first
+func returnsAt(ins code.Instructions, pos int) bool {
+	for pos < len(ins) {
+		instruction, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			return false
+		}
+
+		switch instruction.Op {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			// Jumps only ever go forward, so this always terminates
+			pos = instruction.Operands[0]
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
 // replaceLastPopWithReturn replaces the last "pop" instruction in the current scope with a "return" instruction.
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
@@ -352,13 +911,11 @@ func (c *Compiler) removeLastPop() {
 	c.scopes[c.scopeIndex].lastInstruction = previous
 }
 
-// changeOperand changes the operand of the instruction at the specified position.
-// It takes the position of the instruction and the new operand as parameters.
+// changeOperand changes the operand of the jump at the specified position.
+// Jumps are emitted wide, so the operand always fits.
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
-
-	c.replaceInstruction(opPos, newInstruction)
+	ins := c.currentInstructions()
+	c.replaceInstruction(opPos, code.MakeWide(code.Opcode(ins[opPos+1]), operand))
 }
 
 // replaceInstruction replaces the instruction at the given position with the new instruction.
@@ -372,7 +929,22 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 }
 
 // addConstant adds the given object to the compiler's constants slice and returns its index.
+// Integers and strings are interned: if a constant with the same value was added before,
+// its index is returned instead, so equal literals share one object.
 func (c *Compiler) addConstant(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		if i, ok := c.integerConstants[obj.Value]; ok {
+			return i
+		}
+		c.integerConstants[obj.Value] = len(c.constants)
+	case *object.String:
+		if i, ok := c.stringConstants[obj.Value]; ok {
+			return i
+		}
+		c.stringConstants[obj.Value] = len(c.constants)
+	}
+
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
 }
@@ -380,13 +952,47 @@ func (c *Compiler) addConstant(obj object.Object) int {
 // emit generates a bytecode instruction with the given opcode and operands,
 // adds it to the compiler's instruction list, and returns the position of the
 // newly added instruction.
+//
+// Operands that do not fit the opcode's operand widths are encoded with an OpWide prefix.
+// Jumps are always emitted wide, since their target is not known yet. Once the scope is
+// compiled, narrowJumps narrows the ones whose target fits.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := makeInstruction(op, code.IsJump(op), operands...)
+	if err != nil {
+		c.setOperandErr(err)
+	}
+
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
 	return pos
 }
 
+// makeInstruction encodes op with operands, as a wide instruction if wide is set or the
+// operands do not fit the opcode's operand widths
+func makeInstruction(op code.Opcode, wide bool, operands ...int) ([]byte, error) {
+	if !wide && code.Fits(op, operands...) {
+		return code.Make(op, operands...), nil
+	}
+
+	switch {
+	case op == code.OpGetGlobal || op == code.OpSetGlobal:
+		// The VM's global store has a fixed size
+		return nil, fmt.Errorf("too many global bindings: the limit is %d", math.MaxUint16+1)
+	case !code.FitsWide(operands...):
+		def, _ := code.Lookup(byte(op))
+		return nil, fmt.Errorf("operands %v of %s are out of range", operands, def.Name)
+	}
+
+	return code.MakeWide(op, operands...), nil
+}
+
+// setOperandErr records err unless an earlier error was recorded already
+func (c *Compiler) setOperandErr(err error) {
+	if c.operandErr == nil {
+		c.operandErr = err
+	}
+}
+
 // setLastInstruction updates the previous and last instructions emitted
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
@@ -422,7 +1028,7 @@ func (c *Compiler) enterScope() {
 // leaveScope pops the current scope from the compiler's scope stack and returns the instructions
 // associated with the scope. It also updates the symbol table and scope index accordingly.
 func (c *Compiler) leaveScope() code.Instructions {
-	instructions := c.currentInstructions()
+	instructions := narrowJumps(c.currentInstructions())
 
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
@@ -434,8 +1040,9 @@ func (c *Compiler) leaveScope() code.Instructions {
 // Bytecode returns the bytecode definition held within the compiler
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: narrowJumps(c.currentInstructions()),
 		Constants:    c.constants,
+		Builtins:     c.symbolTable.BuiltinNames(),
 	}
 }
 
@@ -443,4 +1050,7 @@ func (c *Compiler) Bytecode() *Bytecode {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// Builtins holds the names of the builtins the program uses. The operand of
+	// OpGetBuiltin is an index into it.
+	Builtins []string
 }
@@ -2,12 +2,16 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/ast"
 	"github.com/JosueMolinaMorales/orionlang/internal/code"
 	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/optimizer"
 	"github.com/JosueMolinaMorales/orionlang/internal/parser"
 )
 
@@ -30,7 +34,7 @@ func TestBuiltins(t *testing.T) {
 				code.Make(code.OpArray, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
-				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpGetBuiltin, 1),
 				code.Make(code.OpArray, 0),
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 2),
@@ -44,7 +48,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -58,6 +62,47 @@ func TestBuiltins(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestBuiltinNames(t *testing.T) {
+	custom := object.NewRegistry()
+	custom.Register("double", &object.Builtin{})
+
+	tests := []struct {
+		input            string
+		builtins         *object.Registry
+		expectedBuiltins []string
+		expectedErr      string
+	}{
+		{"push([], 1); len([]); push([], 2)", nil, []string{"push", "len"}, ""},
+		{"fn() { first([1]) }; last([1])", nil, []string{"first", "last"}, ""},
+		{"let len = fn(x) { x }; len(1)", nil, []string{}, ""},
+		{"double(1)", custom, []string{"double"}, ""},
+		{"len([])", custom, nil, "undefined variable len"},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		if tt.builtins != nil {
+			comp.SetBuiltins(tt.builtins)
+		}
+
+		err := comp.Compile(parse(tt.input))
+		if tt.expectedErr != "" {
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("wrong compiler error for %q. want=%q, got=%v", tt.input, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		names := comp.Bytecode().Builtins
+		if strings.Join(names, ",") != strings.Join(tt.expectedBuiltins, ",") {
+			t.Errorf("wrong builtins for %q. want=%v, got=%v", tt.input, tt.expectedBuiltins, names)
+		}
+	}
+}
+
 func TestFunctionCalls(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -144,6 +189,92 @@ func TestFunctionCalls(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"id"; 1; "id"; 1; "name"`,
+			expectedConstants: []interface{}{"id", 1, "name"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Field names are not shared, since the VM caches a slot per access
+			input: `let h = {"id": 1}; fn() { h.id + 1 }`,
+			expectedConstants: []interface{}{
+				"id",
+				1,
+				&object.FieldName{Name: "id"},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetField, 2),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantDeduplicationWithState(t *testing.T) {
+	symbolTable := NewSymbolTable()
+
+	first := NewWithState(symbolTable, []object.Object{})
+	err := first.Compile(parse(`"id"; 1`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := first.Bytecode().Constants
+
+	second := NewWithState(symbolTable, constants)
+	err = second.Compile(parse(`1; "id"; "name"`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := second.Bytecode()
+
+	err = testConstants([]interface{}{"id", 1, "name"}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	if bytecode.Constants[0] != constants[0] {
+		t.Errorf("string constant was not shared across sessions")
+	}
+}
+
 func TestCompilerScopes(t *testing.T) {
 	compiler := New()
 	if compiler.scopeIndex != 0 {
@@ -276,14 +407,14 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -291,13 +422,13 @@ func TestIndexExpressions(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2-1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSubtract),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -332,6 +463,18 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"b": 1, "a": 2}`,
+			expectedConstants: []interface{}{"b", 1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "{1: 2 + 3, 4: 5 * 6}",
 			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
@@ -534,186 +677,1061 @@ func TestGlobalLetStatements(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-func TestConditionals(t *testing.T) {
+func TestDestructuringLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input: `
-			if (true) { 10 }; 3333;`,
-			expectedConstants: []interface{}{10, 3333},
+			input:             `let [a, ...r] = [1];`,
+			expectedConstants: []interface{}{1, 0},
 			expectedInstructions: []code.Instructions{
-				// 0000
-				code.Make(code.OpTrue),
-				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
-				// 0004
 				code.Make(code.OpConstant, 0),
-				// 0007
-				code.Make(code.OpJump, 11),
-				// 0010
-				code.Make(code.OpNull),
-				// 0011
-				code.Make(code.OpPop),
-				// 00012
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
-				// 0015
-				code.Make(code.OpPop),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpArrayRest, 1),
+				code.Make(code.OpSetGlobal, 2),
 			},
 		},
 		{
-			input:             `if (true) { 10 } else {20}; 3333;`,
-			expectedConstants: []interface{}{10, 20, 3333},
+			input:             `let {"n": n} = {"n": 2}; n;`,
+			expectedConstants: []interface{}{"n", 2},
 			expectedInstructions: []code.Instructions{
-				// 0000
-				code.Make(code.OpTrue),
-				// 0001
-				code.Make(code.OpJumpNotTruthy, 10),
-				// 0004
 				code.Make(code.OpConstant, 0),
-				// 0007
-				code.Make(code.OpJump, 13),
-				// 0010
 				code.Make(code.OpConstant, 1),
-				// 0013
-				code.Make(code.OpPop),
-				// 0014
-				code.Make(code.OpConstant, 2),
-				// 0017
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
 				code.Make(code.OpPop),
 			},
 		},
-	}
-
-	runCompilerTests(t, tests)
-}
-
-func TestBooleanExpressions(t *testing.T) {
-	tests := []compilerTestCase{
 		{
-			input:             "true",
-			expectedConstants: []interface{}{},
-			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpPop),
+			input: `fn(xs) { let [a, b] = xs; a + b }`,
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpIndex),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpIndex),
+					code.Make(code.OpSetLocal, 3),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpGetLocal, 3),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
 			},
-		},
-		{
-			input:             "false",
-			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpFalse),
+				code.Make(code.OpConstant, 2),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 > 2",
-			expectedConstants: []interface{}{1, 2},
+			// The binding of the value is released once the pattern is compiled
+			input:             `let [a] = [1]; let [b] = [2];`,
+			expectedConstants: []interface{}{1, 0, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 2),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			input: `
+			struct Point { x, y }
+			let p = Point(1, 2);
+			p.x = p.y;
+			`,
+			expectedConstants: []interface{}{
+				&object.StructType{Name: "Point", Fields: []string{"x", "y"}},
+				1, 2, &object.FieldName{Name: "y"}, &object.FieldName{Name: "x"},
+			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetField, 3),
+				code.Make(code.OpSetField, 4),
 				code.Make(code.OpPop),
 			},
 		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
 		{
-			input:             "1 == 2",
-			expectedConstants: []interface{}{1, 2},
+			input:             "1 + 2",
+			expectedConstants: []interface{}{3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1 != 2",
-			expectedConstants: []interface{}{1, 2},
+			input:             "(1 + 2) * 3 - 4 / 2",
+			expectedConstants: []interface{}{7},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpNotEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "true == false",
+			input:             "1 < 2; 1 == 2; true != false",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
 				code.Make(code.OpFalse),
-				code.Make(code.OpEqual),
 				code.Make(code.OpPop),
-			},
-		},
-		{
-			input:             "true != false",
-			expectedConstants: []interface{}{},
-			expectedInstructions: []code.Instructions{
 				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpNotEqual),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "!true",
-			expectedConstants: []interface{}{},
+			input:             `"orion" + "lang"`,
+			expectedConstants: []interface{}{"orionlang"},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "!false",
-			expectedConstants: []interface{}{},
+			input:             "!true; !5; -(1 + 2)",
+			expectedConstants: []interface{}{-3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpFalse),
-				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpPop),
 			},
 		},
-	}
-
-	runCompilerTests(t, tests)
-}
-
-func TestIntegerArithmetic(t *testing.T) {
-	tests := []compilerTestCase{
 		{
-			input:             "1 + 2",
-			expectedConstants: []interface{}{1, 2},
+			input:             "if (1 < 2) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "1; 2; 3;",
-			expectedConstants: []interface{}{1, 2, 3},
+			input:             "if (false) { 10 }; 3333;",
+			expectedConstants: []interface{}{3333},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "5 - 4",
-			expectedConstants: []interface{}{5, 4},
+			input:             "if (true) { let a = 1; a }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x + 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { 2 * 2 }",
+			expectedConstants: []interface{}{
+				4,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Division by zero and string comparison are left to the engines
+			input:             `1 / 0; "a" == "a"`,
+			expectedConstants: []interface{}{1, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDivide),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runFoldedCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let loop = fn(n) { loop(n) };`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let loop = fn(n) { return loop(n); };`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let loop = fn(n) { if (n) { loop(n) } else { n } };`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 15),
+					// 0005
+					code.Make(code.OpGetGlobal, 0),
+					// 0008
+					code.Make(code.OpGetLocal, 0),
+					// 0010
+					code.Make(code.OpTailCall, 1),
+					// 0012
+					code.Make(code.OpJump, 17),
+					// 0015
+					code.Make(code.OpGetLocal, 0),
+					// 0017
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `let loop = fn(n) { loop(n) + 1 };`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLocalRecursion(t *testing.T) {
+	tests := []string{
+		`fn() { let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } }; count(3) }`,
+		`let f = fn() { let g = fn() { g() }; g };`,
+	}
+
+	for _, input := range tests {
+		err := New().Compile(parse(input))
+		if err == nil || !strings.HasPrefix(err.Error(), "undefined variable") {
+			t.Errorf("wrong compiler error for %q. got=%v", input, err)
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b, c, d, e) { a; b; c; d; e }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal2),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal3),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 4),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let x = 1; x + 2; 2 + x; "a" + "b"`,
+			expectedConstants: []interface{}{1, 2, "a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAddConst, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAddConst, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (1 > 2) { 10 }; if (1 < 2) { 20 }; if (1 == 2) { 30 }`,
+			expectedConstants: []interface{}{1, 2, 10, 20, 30},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpJumpIfNotGreater, 15),
+				// 0009
+				code.Make(code.OpConstant, 2),
+				// 0012
+				code.Make(code.OpJump, 16),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpConstant, 1),
+				// 0020
+				code.Make(code.OpConstant, 0),
+				// 0023
+				code.Make(code.OpJumpIfNotGreater, 32),
+				// 0026
+				code.Make(code.OpConstant, 3),
+				// 0029
+				code.Make(code.OpJump, 33),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpPop),
+				// 0034
+				code.Make(code.OpConstant, 0),
+				// 0037
+				code.Make(code.OpConstant, 1),
+				// 0040
+				code.Make(code.OpEqual),
+				// 0041
+				code.Make(code.OpJumpNotTruthy, 50),
+				// 0044
+				code.Make(code.OpConstant, 4),
+				// 0047
+				code.Make(code.OpJump, 51),
+				// 0050
+				code.Make(code.OpNull),
+				// 0051
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runSuperinstructionCompilerTests(t, tests)
+}
+
+func TestWideOperands(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: fmt.Sprintf("fn(%s) { %s }", joinNumbered(identifier, 300, ", "), identifier(299)),
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.MakeWide(code.OpGetLocal, 299),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             fmt.Sprintf("len(%s)", strings.TrimSuffix(strings.Repeat("1, ", 300), ", ")),
+			expectedConstants: []interface{}{1},
+			expectedInstructions: append(append(
+				[]code.Instructions{code.Make(code.OpGetBuiltin, 0)},
+				repeatInstruction(code.Make(code.OpConstant, 0), 300)...),
+				code.MakeWide(code.OpCall, 300),
+				code.Make(code.OpPop),
+			),
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestWideConstantsAndJumps(t *testing.T) {
+	input := joinNumbered(strconv.Itoa, 70001, "; ") + "; if (true) { 1 }"
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ins := compiler.Bytecode().Instructions
+	// Every constant up to 65535 takes an OpConstant and an OpPop
+	lastNarrow := 65535 * 4
+	if string(ins[lastNarrow:lastNarrow+4]) != string(concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpPop),
+		code.MakeWide(code.OpConstant, 65536),
+	})[:4]) {
+		t.Fatalf("wrong instructions at %d: %v", lastNarrow, ins[lastNarrow:lastNarrow+4])
+	}
+
+	// The remaining constants are wide and take 7 bytes each with their OpPop
+	ifPos := lastNarrow + 4 + (70000-65535)*7
+	expected := concatInstructions([]code.Instructions{
+		code.MakeWide(code.OpConstant, 70000),
+		code.Make(code.OpPop),
+		code.Make(code.OpTrue),
+		code.MakeWide(code.OpJumpNotTruthy, ifPos+16),
+		code.Make(code.OpConstant, 1),
+		code.MakeWide(code.OpJump, ifPos+17),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+	})
+	err = testInstructions([]code.Instructions{expected}, ins[ifPos-7:])
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestLongBranches(t *testing.T) {
+	// Every constant takes an OpConstant and an OpPop, except the last one in the
+	// branch, whose value is the value of the if
+	branch := joinNumbered(strconv.Itoa, 20001, "; ")
+	afterBranch := 1 + 6 + 20001*4 - 1 + 6
+
+	tests := []compilerTestCase{
+		{
+			// Jumps whose target is out of reach of a two-byte operand are wide
+			input:             fmt.Sprintf("if (true) { %s }", branch),
+			expectedConstants: numbers(20001),
+			expectedInstructions: append(append(
+				[]code.Instructions{
+					code.Make(code.OpTrue),
+					code.MakeWide(code.OpJumpNotTruthy, afterBranch),
+				},
+				constantsAndPops(20000)...),
+				code.Make(code.OpConstant, 20000),
+				code.MakeWide(code.OpJump, afterBranch+1),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			// The others are narrow, even if the instructions are longer than that
+			input:             fmt.Sprintf("if (true) { 0 }; %s", branch),
+			expectedConstants: numbers(20001),
+			expectedInstructions: append(
+				[]code.Instructions{
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 10),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpJump, 11),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				},
+				constantsAndPops(20001)...,
+			),
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			joinNumbered(func(i int) string { return "let " + identifier(i) + " = 0" }, 65537, "; "),
+			"too many global bindings: the limit is 65536",
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// joinNumbered calls format with every number from 0 to n-1 and joins the results with sep
+func joinNumbered(format func(int) string, n int, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = format(i)
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// identifier returns a distinct identifier for i, spelled with letters only since
+// identifiers can not contain digits. The prefix keeps it from spelling a keyword.
+func identifier(i int) string {
+	name := []byte{}
+	for ; i >= 26; i = i/26 - 1 {
+		name = append([]byte{byte('a' + i%26)}, name...)
+	}
+
+	return "v" + string(append([]byte{byte('a' + i)}, name...))
+}
+
+// numbers returns every number from 0 to n-1
+func numbers(n int) []interface{} {
+	out := make([]interface{}, n)
+	for i := range out {
+		out[i] = i
+	}
+
+	return out
+}
+
+// constantsAndPops returns an OpConstant and an OpPop for every constant from 0 to n-1
+func constantsAndPops(n int) []code.Instructions {
+	out := []code.Instructions{}
+	for i := 0; i < n; i++ {
+		out = append(out, code.Make(code.OpConstant, i), code.Make(code.OpPop))
+	}
+
+	return out
+}
+
+func repeatInstruction(ins code.Instructions, n int) []code.Instructions {
+	out := make([]code.Instructions, n)
+	for i := range out {
+		out[i] = ins
+	}
+
+	return out
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `{}.f(1)`,
+			expectedConstants: []interface{}{1, &object.FieldName{Name: "f"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCallMethod, 1, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{}.f`,
+			expectedConstants: []interface{}{&object.FieldName{Name: "f"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpGetField, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { self }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetSelf),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			if (true) { 10 }; 3333;`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 00012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (true) { 10 } else {20}; 3333;`,
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `match 1 { 1 => 10, _ => 20 }`,
+			expectedConstants: []interface{}{
+				1,
+				&object.MatchTable{Integers: map[int64]int{1: 0}, Strings: map[string]int{}},
+				10,
+				20,
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpMatchTable, 1, 2),
+				// 0014
+				code.Make(code.OpCase, 20),
+				// 0017
+				code.Make(code.OpCase, 26),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				// 0023
+				code.Make(code.OpJump, 33),
+				// 0026
+				code.Make(code.OpConstant, 3),
+				// 0029
+				code.Make(code.OpJump, 33),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `match "b" { "a" => 1, -2 => 2, "a" => 3 }`,
+			expectedConstants: []interface{}{
+				"b",
+				&object.MatchTable{Integers: map[int64]int{-2: 1}, Strings: map[string]int{"a": 0}},
+				1,
+				2,
+				3,
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpMatchTable, 1, 4),
+				// 0008
+				code.Make(code.OpCase, 20),
+				// 0011
+				code.Make(code.OpCase, 26),
+				// 0014
+				code.Make(code.OpCase, 32),
+				// 0017
+				code.Make(code.OpCase, 38),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				// 0023
+				code.Make(code.OpJump, 39),
+				// 0026
+				code.Make(code.OpConstant, 3),
+				// 0029
+				code.Make(code.OpJump, 39),
+				// 0032
+				code.Make(code.OpConstant, 4),
+				// 0035
+				code.Make(code.OpJump, 39),
+				// 0038
+				code.Make(code.OpNull),
+				// 0039
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `match [1, 2] { [h, ...t] if h > 0 => t }`,
+			expectedConstants: []interface{}{1, 2, 0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpArray, 2),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpMatchArray, 1, 1),
+				// 0019
+				code.Make(code.OpJumpNotTruthy, 57),
+				// 0022
+				code.Make(code.OpGetGlobal, 0),
+				// 0025
+				code.Make(code.OpConstant, 2),
+				// 0028
+				code.Make(code.OpIndex),
+				// 0029
+				code.Make(code.OpSetGlobal, 1),
+				// 0032
+				code.Make(code.OpGetGlobal, 0),
+				// 0035
+				code.Make(code.OpArrayRest, 1),
+				// 0038
+				code.Make(code.OpSetGlobal, 2),
+				// 0041
+				code.Make(code.OpGetGlobal, 1),
+				// 0044
+				code.Make(code.OpConstant, 2),
+				// 0047
+				code.Make(code.OpGreaterThan),
+				// 0048
+				code.Make(code.OpJumpNotTruthy, 57),
+				// 0051
+				code.Make(code.OpGetGlobal, 2),
+				// 0054
+				code.Make(code.OpJump, 58),
+				// 0057
+				code.Make(code.OpNull),
+				// 0058
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `match {"a": 1} { {"a": a} => a }`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpHash, 2),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpMatchHash),
+				// 0016
+				code.Make(code.OpJumpNotTruthy, 45),
+				// 0019
+				code.Make(code.OpGetGlobal, 0),
+				// 0022
+				code.Make(code.OpConstant, 0),
+				// 0025
+				code.Make(code.OpHasKey),
+				// 0026
+				code.Make(code.OpJumpNotTruthy, 45),
+				// 0029
+				code.Make(code.OpGetGlobal, 0),
+				// 0032
+				code.Make(code.OpConstant, 0),
+				// 0035
+				code.Make(code.OpIndex),
+				// 0036
+				code.Make(code.OpSetGlobal, 1),
+				// 0039
+				code.Make(code.OpGetGlobal, 1),
+				// 0042
+				code.Make(code.OpJump, 46),
+				// 0045
+				code.Make(code.OpNull),
+				// 0046
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The binding of the subject is released once the match is compiled
+			input:             `match 1 { x => x }; match 2 { x => x }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpSetGlobal, 1),
+				// 0012
+				code.Make(code.OpGetGlobal, 1),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpConstant, 1),
+				// 0023
+				code.Make(code.OpSetGlobal, 0),
+				// 0026
+				code.Make(code.OpGetGlobal, 0),
+				// 0029
+				code.Make(code.OpSetGlobal, 2),
+				// 0032
+				code.Make(code.OpGetGlobal, 2),
+				// 0035
+				code.Make(code.OpJump, 39),
+				// 0038
+				code.Make(code.OpNull),
+				// 0039
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 > 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 == 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 != 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true == false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true != false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; 2; 3;",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "5 - 4",
+			expectedConstants: []interface{}{5, 4},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
@@ -723,20 +1741,20 @@ func TestIntegerArithmetic(t *testing.T) {
 		},
 		{
 			input:             "5 / 5",
-			expectedConstants: []interface{}{5, 5},
+			expectedConstants: []interface{}{5},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpDivide),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input:             "5 * 5",
-			expectedConstants: []interface{}{5, 5},
+			expectedConstants: []interface{}{5},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpMultiply),
 				code.Make(code.OpPop),
 			},
@@ -755,13 +1773,38 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+// runCompilerTests compiles each input without superinstructions, so the expected
+// instructions spell out the plain opcode sequences
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
+	runCompilerTestsWith(t, tests, parse, false)
+}
+
+// runSuperinstructionCompilerTests compiles each input with superinstructions enabled
+func runSuperinstructionCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	runCompilerTestsWith(t, tests, parse, true)
+}
+
+// runFoldedCompilerTests compiles each input after running the constant-folding pass
+func runFoldedCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	runCompilerTestsWith(t, tests, func(input string) *ast.Program {
+		return optimizer.Fold(parse(input)).(*ast.Program)
+	}, false)
+}
+
+func runCompilerTestsWith(t *testing.T, tests []compilerTestCase, parse func(string) *ast.Program, superinstructions bool) {
+	t.Helper()
+
 	for _, tt := range tests {
 		program := parse(tt.input)
 
 		compiler := New()
+		compiler.SetSuperinstructions(superinstructions)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -842,6 +1885,29 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
 			}
+		case *object.FieldName:
+			name, ok := actual[i].(*object.FieldName)
+			if !ok || name.Name != constant.Name {
+				return fmt.Errorf("constant %d - wrong field name. want=%q, got=%v", i, constant.Name, actual[i])
+			}
+		case *object.MatchTable:
+			table, ok := actual[i].(*object.MatchTable)
+			if !ok {
+				return fmt.Errorf("constant %d - not a match table: %T", i, actual[i])
+			}
+
+			if !reflect.DeepEqual(table, constant) {
+				return fmt.Errorf("constant %d - wrong match table. want=%+v, got=%+v", i, constant, table)
+			}
+		case *object.StructType:
+			def, ok := actual[i].(*object.StructType)
+			if !ok {
+				return fmt.Errorf("constant %d - not a struct type: %T", i, actual[i])
+			}
+
+			if def.Inspect() != constant.Inspect() {
+				return fmt.Errorf("constant %d - wrong struct type. want=%q, got=%q", i, constant.Inspect(), def.Inspect())
+			}
 		}
 	}
 
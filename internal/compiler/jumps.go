@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"math"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/code"
+)
+
+// placedInstruction is a decoded instruction together with the position it was decoded from
+type placedInstruction struct {
+	code.Instruction
+	pos int
+}
+
+// narrowJumps returns ins with every wide jump narrowed whose target fits a two-byte
+// operand once the instructions are relocated.
+//
+// Jumps are emitted wide because their target is not known when they are emitted. Once a
+// scope is compiled every target is known, and narrowing jumps only moves instructions
+// back, so a jump whose target fits keeps fitting as others are narrowed. Jumps are
+// narrowed until no more targets come within reach, and every jump is then pointed at
+// the relocated position of its target. Instructions that are not jumps keep their
+// width, and narrowing instructions that were narrowed before changes nothing.
+func narrowJumps(ins code.Instructions) code.Instructions {
+	instructions := []*placedInstruction{}
+	for pos := 0; pos < len(ins); {
+		decoded, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			return ins
+		}
+
+		instructions = append(instructions, &placedInstruction{Instruction: decoded, pos: pos})
+		pos += decoded.Width
+	}
+
+	newPositions := relocate(instructions, len(ins))
+	for narrowed := true; narrowed; {
+		narrowed = false
+		for i, placed := range instructions {
+			switch {
+			case placed.Op == code.OpMatchTable:
+				cases := instructions[i+1 : i+1+placed.Operands[1]]
+				if narrowable(cases, newPositions) {
+					for _, entry := range cases {
+						narrow(entry)
+					}
+					narrowed = true
+				}
+			case placed.Op == code.OpCase:
+				// Entries are narrowed together with the rest of their table
+			case placed.Wide && code.IsJump(placed.Op) && newPositions[placed.Operands[0]] <= math.MaxUint16:
+				narrow(placed)
+				narrowed = true
+			}
+		}
+
+		newPositions = relocate(instructions, len(ins))
+	}
+
+	out := code.Instructions{}
+	for _, placed := range instructions {
+		operands := placed.Operands
+		if code.IsJump(placed.Op) {
+			operands = []int{newPositions[operands[0]]}
+		}
+
+		if placed.Wide {
+			out = append(out, code.MakeWide(placed.Op, operands...)...)
+		} else {
+			out = append(out, code.Make(placed.Op, operands...)...)
+		}
+	}
+
+	return out
+}
+
+// narrowable reports whether the entries of a table are wide and all of their targets
+// fit a two-byte operand once the instructions are relocated
+func narrowable(cases []*placedInstruction, newPositions map[int]int) bool {
+	for _, entry := range cases {
+		if !entry.Wide || newPositions[entry.Operands[0]] > math.MaxUint16 {
+			return false
+		}
+	}
+
+	return len(cases) > 0
+}
+
+// narrow encodes placed with two-byte operands from now on
+func narrow(placed *placedInstruction) {
+	placed.Wide = false
+	placed.Width = len(code.Make(placed.Op, placed.Operands...))
+}
+
+// relocate maps the position of every instruction, and end, the length of the
+// instructions they were decoded from, to its position once they are encoded with
+// their current widths
+func relocate(instructions []*placedInstruction, end int) map[int]int {
+	newPositions := make(map[int]int, len(instructions)+1)
+	newPos := 0
+	for _, placed := range instructions {
+		newPositions[placed.pos] = newPos
+		newPos += placed.Width
+	}
+	newPositions[end] = newPos
+
+	return newPositions
+}
@@ -31,6 +31,20 @@ type SymbolTable struct {
 
 	store          map[string]Symbol
 	numDefinitions int
+	// builtinNames holds the name of every builtin defined in the table by its index
+	builtinNames []string
+	// blocks holds, for every block entered and not left yet, the bindings that names
+	// defined in the block had before it
+	blocks []map[string]shadowedSymbol
+	// freeScratch holds the slots of the hidden bindings released by ReleaseScratch
+	freeScratch []int
+}
+
+// shadowedSymbol is the binding a name had before a block redefined it. ok is false if
+// the name was not bound.
+type shadowedSymbol struct {
+	symbol Symbol
+	ok     bool
 }
 
 // NewSymbolTable creates a new symbol table and returns a pointer to it.
@@ -48,17 +62,105 @@ func (s *SymbolTable) Define(name string) Symbol {
 	} else {
 		symbol.Scope = LocalScope
 	}
+	if len(s.blocks) > 0 {
+		block := s.blocks[len(s.blocks)-1]
+		if _, ok := block[name]; !ok {
+			previous, ok := s.store[name]
+			block[name] = shadowedSymbol{symbol: previous, ok: ok}
+		}
+	}
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// EnterBlock starts a block, such as a match arm, whose definitions are only visible
+// until LeaveBlock is called. They are given slots of their own, so they do not
+// overwrite the values of the names they shadow.
+func (s *SymbolTable) EnterBlock() {
+	s.blocks = append(s.blocks, map[string]shadowedSymbol{})
+}
+
+// LeaveBlock ends the block started by the last call to EnterBlock, restoring the names
+// defined in it to what they referred to before
+func (s *SymbolTable) LeaveBlock() {
+	block := s.blocks[len(s.blocks)-1]
+	s.blocks = s.blocks[:len(s.blocks)-1]
+
+	for name, previous := range block {
+		if previous.ok {
+			s.store[name] = previous.symbol
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+// DefineScratch returns the symbol of a hidden binding that is only used until
+// ReleaseScratch is called with it, such as the value a match tests its arms against.
+// Code can not refer to it by name. It reuses the slot of a released binding if there is
+// one, so a scope only has as many hidden slots as it uses at the same time.
+func (s *SymbolTable) DefineScratch(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: GlobalScope}
+	if s.Outer != nil {
+		symbol.Scope = LocalScope
+	}
+
+	if n := len(s.freeScratch); n > 0 {
+		symbol.Index = s.freeScratch[n-1]
+		s.freeScratch = s.freeScratch[:n-1]
+	} else {
+		symbol.Index = s.numDefinitions
+		s.numDefinitions++
+	}
+	return symbol
+}
+
+// ReleaseScratch makes the slot of a binding returned by DefineScratch available to the
+// next call to DefineScratch
+func (s *SymbolTable) ReleaseScratch(symbol Symbol) {
+	s.freeScratch = append(s.freeScratch, symbol.Index)
+}
+
+// DefineBuiltin defines name as the builtin at index in the builtins a program uses
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
+
+	for len(s.builtinNames) <= index {
+		s.builtinNames = append(s.builtinNames, "")
+	}
+	s.builtinNames[index] = name
 	return symbol
 }
 
+// UseBuiltin defines name as a builtin in the outermost symbol table, indexed after
+// every builtin defined so far
+func (s *SymbolTable) UseBuiltin(name string) Symbol {
+	root := s.root()
+	return root.DefineBuiltin(len(root.builtinNames), name)
+}
+
+// BuiltinNames returns the names of the builtins defined in the outermost symbol table,
+// by index
+func (s *SymbolTable) BuiltinNames() []string {
+	return append([]string{}, s.root().builtinNames...)
+}
+
+func (s *SymbolTable) root() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// ResolveLocal looks up a symbol by name in this symbol table only, ignoring the
+// tables it is enclosed by.
+func (s *SymbolTable) ResolveLocal(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	return obj, ok
+}
+
 // Resolve looks up a symbol by name in the symbol table and returns the corresponding symbol object and a boolean indicating if the symbol was found.
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
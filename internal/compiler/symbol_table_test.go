@@ -33,6 +33,85 @@ func TestDefineResolveBuiltins(t *testing.T) {
 	}
 }
 
+func TestUseBuiltin(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+
+	expected := []Symbol{
+		{Name: "b", Scope: BuiltinScope, Index: 0},
+		{Name: "a", Scope: BuiltinScope, Index: 1},
+	}
+
+	for i, table := range []*SymbolTable{local, global} {
+		if result := table.UseBuiltin(expected[i].Name); result != expected[i] {
+			t.Errorf("expected %s to be defined as %+v, got=%+v", expected[i].Name, expected[i], result)
+		}
+	}
+
+	for _, sym := range expected {
+		result, ok := global.Resolve(sym.Name)
+		if !ok || result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	names := local.BuiltinNames()
+	if len(names) != 2 || names[0] != "b" || names[1] != "a" {
+		t.Errorf("wrong builtin names. want=[b a], got=%v", names)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+
+	global.EnterBlock()
+	shadow := global.Define("a")
+	b := global.Define("b")
+	if result, _ := global.Resolve("a"); result != shadow {
+		t.Errorf("expected a to resolve to %+v inside the block, got=%+v", shadow, result)
+	}
+	if b.Index != 2 {
+		t.Errorf("expected b to get a slot of its own. got=%+v", b)
+	}
+	global.LeaveBlock()
+
+	if result, _ := global.Resolve("a"); result != a {
+		t.Errorf("expected a to resolve to %+v after the block, got=%+v", a, result)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("expected b to be undefined after the block")
+	}
+}
+
+func TestScratch(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := global.DefineScratch("$match")
+	nested := global.DefineScratch("$match")
+	if first.Index != 1 || nested.Index != 2 {
+		t.Errorf("expected bindings in use at the same time to get slots of their own. got=%+v, %+v", first, nested)
+	}
+	if _, ok := global.Resolve("$match"); ok {
+		t.Errorf("expected $match to be hidden")
+	}
+	global.ReleaseScratch(nested)
+	global.ReleaseScratch(first)
+
+	if reused := global.DefineScratch("$let"); reused.Index != 1 {
+		t.Errorf("expected a released slot to be reused. got=%+v", reused)
+	}
+	if b := global.Define("b"); b.Index != 3 {
+		t.Errorf("expected b to get a slot of its own. got=%+v", b)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	if scratch := local.DefineScratch("$match"); scratch != (Symbol{Name: "$match", Scope: LocalScope, Index: 0}) {
+		t.Errorf("expected a local scratch binding. got=%+v", scratch)
+	}
+}
+
 func TestResolveNestedLocal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -1,19 +1,68 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/ast"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
 )
 
+// receiverName is the name the receiver of a method call is bound to in the function's
+// environment. It is bound before the parameters, so a parameter or let statement named
+// self shadows it.
+const receiverName = "self"
+
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
+// standardBuiltins are the builtins of environments that have not been given their own
+var standardBuiltins = object.NewStandardRegistry()
+
+// MaxCallDepth is the largest number of function calls an evaluation may have in progress
+// at once, like the VM's default limit on frames. Deeper recursion stops the evaluation
+// with a LimitError instead of overflowing the Go stack.
+const MaxCallDepth = 1<<16 - 1
+
+// Limits bounds how long an evaluation started by EvalContext may run and how much
+// memory it may allocate
+type Limits struct {
+	// MaxSteps is the largest number of steps the evaluation may take, one for every
+	// node evaluated. Zero means no limit.
+	MaxSteps int
+	// MaxMemory is the largest number of bytes the evaluation may allocate for arrays,
+	// hashes, strings and structs, counting every allocation. Zero means no limit.
+	MaxMemory int
+}
+
+// EvalContext evaluates node like Eval, but stops once ctx is done or the evaluation
+// exceeds limits or MaxCallDepth. It then returns a *object.LimitError, which matches
+// object.ErrExecutionLimit with errors.Is. Errors raised by the program itself are
+// returned as *object.Error values, like Eval does.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) (object.Object, error) {
+	budget := object.NewBudget(ctx, limits.MaxSteps, limits.MaxMemory)
+	previous := env.SetBudget(budget)
+	defer env.SetBudget(previous)
+
+	result := Eval(node, env)
+	if err := budget.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if budget := env.Budget(); budget != nil {
+		if err := budget.Step(); err != nil {
+			return newError("%s", err)
+		}
+	}
+
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -31,9 +80,21 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			return destructure(node.Pattern, val, env)
+		}
 		env.Set(node.Name.Value, val)
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range node.Fields {
+			fields = append(fields, f.Value)
+		}
+		env.Set(node.Name.Value, &object.StructType{Name: node.Name.Value, Fields: fields})
 	// Expressions
 	case *ast.CallExpression:
+		if field, ok := node.Function.(*ast.FieldExpression); ok {
+			return evalMethodCall(field, node.Arguments, env)
+		}
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -42,11 +103,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(env, function, NULL, args)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -56,7 +119,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -73,6 +136,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.FieldExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalFieldExpression(left, node.Field.Value)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	case *ast.ArrayLiteral:
@@ -80,6 +151,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
+		if err := allocate(env, object.ArraySize(len(elements))); err != nil {
+			return err
+		}
 		return &object.Array{Elements: elements}
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
@@ -99,29 +173,105 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	if err := allocate(env, object.HashSize(len(node.Keys))); err != nil {
+		return err
+	}
 
-	for keyNode, valueNode := range node.Pairs {
+	hash := object.NewHash()
+
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+		hashKey, err := object.AsHashable(key)
+		if err != nil {
+			return newError("%s", err)
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
+}
+
+func evalFieldExpression(left object.Object, name string) object.Object {
+	switch left := left.(type) {
+	case *object.Struct:
+		slot, ok := left.Def.FieldIndex(name)
+		if !ok {
+			return newError("unknown field %s on %s", name, left.Def.Name)
+		}
+		return left.Fields[slot]
+	case *object.Hash:
+		// h.key is sugar for h["key"]
+		return evalHashIndexExpression(left, &object.String{Value: name})
+	case *object.HostObject:
+		value, ok := left.Field(name)
+		if !ok {
+			return newError("unknown field %s on %s", name, left.Def.Name)
+		}
+		return value
+	default:
+		return newError("field access not supported: %s", left.Type())
+	}
+}
+
+// evalMethodCall calls the function stored under field.Field in the value of field.Left,
+// binding that value to self for the duration of the call
+func evalMethodCall(field *ast.FieldExpression, arguments []ast.Expression, env *object.Environment) object.Object {
+	receiver := Eval(field.Left, env)
+	if isError(receiver) {
+		return receiver
+	}
+
+	args := evalExpressions(arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	method := evalFieldExpression(receiver, field.Field.Value)
+	if isError(method) {
+		return method
+	}
+
+	return applyFunction(env, method, receiver, args)
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	target, ok := node.Target.(*ast.FieldExpression)
+	if !ok {
+		return newError("cannot assign to %s", node.Target.String())
+	}
+
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	s, ok := left.(*object.Struct)
+	if !ok {
+		return newError("field assignment not supported: %s", left.Type())
+	}
+
+	slot, ok := s.Def.FieldIndex(target.Field.Value)
+	if !ok {
+		return newError("unknown field %s on %s", target.Field.Value, s.Def.Name)
+	}
+
+	s.Fields[slot] = value
+	return value
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -137,17 +287,17 @@ func evalIndexExpression(left, index object.Object) object.Object {
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	key, err := object.AsHashable(index)
+	if err != nil {
+		return newError("%s", err)
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
@@ -162,17 +312,54 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// builtinContext returns the context of a builtin called from env. Functions the builtin
+// calls are applied in env, and their errors returned as Go errors.
+func builtinContext(env *object.Environment) *object.BuiltinContext {
+	return &object.BuiltinContext{
+		Budget: env.Budget(),
+		IO:     env.IO(),
+		Call: func(fn object.Object, args ...object.Object) (object.Object, error) {
+			result := applyFunction(env, fn, NULL, args)
+			if errObj, ok := result.(*object.Error); ok {
+				return nil, errors.New(errObj.Message)
+			}
+			return result, nil
+		},
+	}
+}
+
+// applyFunction calls fn with args from the environment env. receiver is bound to self
+// inside fn, and is NULL unless fn is being called as a method.
+func applyFunction(env *object.Environment, fn object.Object, receiver object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		defer env.LeaveCall()
+		if env.EnterCall() > MaxCallDepth {
+			err := env.Budget().Stop(object.CountLimitError(MaxCallDepth, "nested function calls"))
+			return newError("%s", err)
+		}
+
+		extendedEnv, err := extendFunctionEnv(fn, receiver, args)
+		if err != nil {
+			return err
+		}
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		if result := fn.Fn(builtinContext(env), args...); result != nil {
 			return result
 		}
 		return NULL
+	case *object.StructType:
+		if len(args) != len(fn.Fields) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Fields), len(args))
+		}
+		if err := allocate(env, object.ArraySize(len(args))); err != nil {
+			return err
+		}
+		fields := make([]object.Object, len(args))
+		copy(fields, args)
+		return &object.Struct{Def: fn, Fields: fields}
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -186,14 +373,22 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+// extendFunctionEnv binds the receiver and the arguments of a call to fn in a new
+// environment. It returns an error if the call does not pass one argument for every
+// parameter.
+func extendFunctionEnv(fn *object.Function, receiver object.Object, args []object.Object) (*object.Environment, *object.Error) {
+	if len(args) != len(fn.Parameters) {
+		return nil, newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
+	env.Set(receiverName, receiver)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
 	}
 
-	return env
+	return env, nil
 }
 
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
@@ -215,10 +410,19 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	builtins := env.Builtins()
+	if builtins == nil {
+		builtins = standardBuiltins
+	}
+	if builtin, ok := builtins.Lookup(node.Value); ok {
 		return builtin
 	}
 
+	if node.Value == receiverName {
+		// self is null outside of any function
+		return NULL
+	}
+
 	return newError("identifier not found: " + node.Value)
 }
 
@@ -270,12 +474,222 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		// The names an arm binds are only visible in its guard and body
+		armEnv := object.NewEnclosedEnvironment(env)
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return NULL
+}
+
+// matchPattern reports whether value matches pattern, binding any names in the
+// pattern into env as it goes
+func matchPattern(pattern ast.Pattern, value object.Object, env *object.Environment) (bool, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true, nil
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+		return true, nil
+	case *ast.LiteralPattern:
+		literal := Eval(pattern.Value, env)
+		if isError(literal) {
+			return false, literal
+		}
+		return literalsEqual(value, literal), nil
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok {
+			return false, nil
+		}
+
+		length := len(array.Elements)
+		if length < len(pattern.Elements) || (pattern.Rest == nil && length != len(pattern.Elements)) {
+			return false, nil
+		}
+
+		for i, el := range pattern.Elements {
+			matched, err := matchPattern(el, array.Elements[i], env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+
+		if pattern.Rest != nil {
+			if err := allocate(env, object.ArraySize(length-len(pattern.Elements))); err != nil {
+				return false, err
+			}
+			rest := make([]object.Object, length-len(pattern.Elements))
+			copy(rest, array.Elements[len(pattern.Elements):])
+			return matchPattern(pattern.Rest, &object.Array{Elements: rest}, env)
+		}
+
+		return true, nil
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+
+		for i, keyNode := range pattern.Keys {
+			key := Eval(keyNode, env)
+			if isError(key) {
+				return false, key
+			}
+
+			hashKey, hashErr := object.AsHashable(key)
+			if hashErr != nil {
+				return false, newError("%s", hashErr)
+			}
+
+			element, ok := hash.Get(hashKey)
+			if !ok {
+				return false, nil
+			}
+
+			matched, err := matchPattern(pattern.Values[i], element, env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+
+		return true, nil
+	default:
+		return false, newError("unknown pattern: %T", pattern)
+	}
+}
+
+// destructure binds the names in pattern to the matching parts of value.
+// Missing array elements and hash keys bind to null.
+func destructure(pattern ast.Pattern, value object.Object, env *object.Environment) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+		return nil
+	case *ast.ArrayPattern:
+		for i, el := range pattern.Elements {
+			element := evalIndexExpression(value, &object.Integer{Value: int64(i)})
+			if isError(element) {
+				return element
+			}
+
+			err := destructure(el, orEmpty(el, element), env)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			array, ok := value.(*object.Array)
+			if !ok {
+				return newError("rest pattern not supported: %s", value.Type())
+			}
+
+			start := len(pattern.Elements)
+			if start > len(array.Elements) {
+				start = len(array.Elements)
+			}
+			if err := allocate(env, object.ArraySize(len(array.Elements)-start)); err != nil {
+				return err
+			}
+			rest := make([]object.Object, len(array.Elements)-start)
+			copy(rest, array.Elements[start:])
+
+			return destructure(pattern.Rest, &object.Array{Elements: rest}, env)
+		}
+
+		return nil
+	case *ast.HashPattern:
+		for i, keyNode := range pattern.Keys {
+			key := Eval(keyNode, env)
+			if isError(key) {
+				return key
+			}
+
+			element := evalIndexExpression(value, key)
+			if isError(element) {
+				return element
+			}
+
+			err := destructure(pattern.Values[i], orEmpty(pattern.Values[i], element), env)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return newError("unsupported pattern in let statement: %s", pattern.String())
+	}
+}
+
+// orEmpty returns value, or an empty array or hash for a nested array or hash pattern to
+// destructure when value is missing, so that every name in the pattern binds null
+func orEmpty(pattern ast.Pattern, value object.Object) object.Object {
+	if value != NULL {
+		return value
+	}
+
+	switch pattern.(type) {
+	case *ast.ArrayPattern:
+		return &object.Array{Elements: []object.Object{}}
+	case *ast.HashPattern:
+		return object.NewHash()
+	default:
+		return value
+	}
+}
+
+// literalsEqual reports whether value is a literal of the same type and value as literal
+func literalsEqual(value, literal object.Object) bool {
+	switch literal := literal.(type) {
+	case *object.Integer:
+		v, ok := value.(*object.Integer)
+		return ok && v.Value == literal.Value
+	case *object.String:
+		v, ok := value.(*object.String)
+		return ok && v.Value == literal.Value
+	case *object.Boolean:
+		v, ok := value.(*object.Boolean)
+		return ok && v.Value == literal.Value
+	default:
+		return false
+	}
+}
+
+func evalInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right, env)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -287,15 +701,23 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
+func evalStringInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "+":
+		if err := allocate(env, object.StringSize(len(leftVal)+len(rightVal))); err != nil {
+			return err
+		}
+		return &object.String{Value: leftVal + rightVal}
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
@@ -378,6 +800,16 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// allocate accounts for size bytes of memory about to be allocated by the evaluation
+// running in env. It returns an error once the evaluation has run out of memory.
+func allocate(env *object.Environment, size int) object.Object {
+	if err := env.Budget().Allocate(size); err != nil {
+		return newError("%s", err)
+	}
+
+	return nil
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -1,8 +1,15 @@
 package evaluator_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/JosueMolinaMorales/orionlang/internal/ast"
 	"github.com/JosueMolinaMorales/orionlang/internal/evaluator"
 	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
@@ -48,6 +55,58 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestHashKeyCollisions(t *testing.T) {
+	original := object.HashString
+	object.HashString = func(string) uint64 { return 1 }
+	defer func() { object.HashString = original }()
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"a": 1, "b": 2}["a"]`, 1},
+		{`{"a": 1, "b": 2}["b"]`, 2},
+		{`{"a": 1, "b": 2}["c"]`, nil},
+		{`let h = {"a": 1, "b": 2, "a": 3}; h["a"] + h["b"]`, 5},
+		{`match {"a": 1, "b": 2} { {"b": x} => x, _ => 0 }`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{[1, 2]: 3}[[1, 2]]`, 3},
+		{`{[1, 2]: 3}[[2, 1]]`, nil},
+		{`let grid = {[0, 0]: 1, [0, 1]: 2}; grid[[0, 1]]`, 2},
+		{`{[[1], "a"]: 5}[[[1], "a"]]`, 5},
+		{`{{"a": 1, "b": 2}: 7}[{"b": 2, "a": 1}]`, 7},
+		{`{{"a": 1}: 7}[{"a": 2}]`, nil},
+		{`let h = {[1]: 1, [1]: 2}; h[[1]]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -74,20 +133,40 @@ func TestHashLiterals(t *testing.T) {
 		evaluator.FALSE.HashKey():                  6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, pair := range result.OrderedPairs() {
+		expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
 		}
 
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
 
+func TestHashInspectOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`{3: "x", 1: "y", 2: "z"}`, "{3: x, 1: y, 2: z}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`{"outer": {"z": 1, "y": 2}, true: false}`, "{outer: {z: 1, y: 2}, true: false}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect output. want=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -230,6 +309,24 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestBuiltinRegistry(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Register("double", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}})
+
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+
+	testIntegerObject(t, evaluator.Eval(parse("let f = fn(x) { double(x) }; f(21)"), env), 42)
+
+	result := evaluator.Eval(parse("len([])"), env)
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Message != "identifier not found: len" {
+		t.Errorf("expected identifier not found error. got=%v", result)
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -245,6 +342,26 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"orion" == "orion"`, true},
+		{`"orion" == "lang"`, false},
+		{`"orion" != "lang"`, true},
+		{`"orion" + "lang" == "orionlang"`, true},
+		{`let s = "orion"; let t = "or" + "ion"; s == t`, true},
+		{`let s = "orion"; let t = "or" + "ion"; s != t`, false},
+		{`"1" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -271,6 +388,8 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		{"let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+		{"fn() { let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5) }()", 120},
 	}
 
 	for _, tt := range tests {
@@ -319,6 +438,96 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let [a, b] = [1, 2]; a + b`, 3},
+		{`let [a, b, ...rest] = [1, 2, 3, 4]; a + b + len(rest)`, 5},
+		{`let [a, b, c] = [1, 2]; c`, nil},
+		{`let [a, ...rest] = []; len(rest)`, 0},
+		{`let [_, [x, y]] = [1, [2, 3]]; x * y`, 6},
+		{`let {"name": n, "age": a} = {"name": "orion", "age": 3}; a`, 3},
+		{`let {"name": n, "missing": m} = {"name": "x"}; m`, nil},
+		{`let {"pos": [x, y]} = {"pos": [4, 5]}; x + y`, 9},
+		{`let f = fn(xs) { let [a, ...t] = xs; a + len(t) }; f([10, 20, 30])`, 12},
+		{`let [a, [b]] = [1]; a`, 1},
+		{`let [a, [b]] = [1]; b`, nil},
+		{`let [a, [b, ...c]] = [1]; len(c)`, 0},
+		{`let {"pos": {"x": x}} = {}; x`, nil},
+		{`let f = fn(xs) { let [[a], {"k": b}] = xs; b }; f([[1], {"k": 2}])`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		integer, ok := tt.expected.(int)
+
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`struct Point { x, y }; let p = Point(1, 2); p.x + p.y`, 3},
+		{`struct P { x }; let p = P(1); p.x = 5; p.x`, 5},
+		{`struct P { x }; let p = P(1); p.x = 5`, 5},
+		{`struct P { a, b }; let p = P(1, 2); let q = P(p, 3); q.a.b`, 2},
+		{`struct P { x }; let inc = fn(p) { p.x = p.x + 1 }; let p = P(1); inc(p); inc(p); p.x`, 3},
+		{`struct P { x, y }; let p = P(1, 2); let q = P(3, 4); p.x = q.y = 7; p.x + q.y`, 14},
+		{`struct Empty {}; len([Empty(), Empty()])`, 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestDotAccessAndMethodCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let h = {"a": 1, "b": 2}; h.a + h.b`, 3},
+		{`{"a": 1}.missing`, nil},
+		{`let h = {"add": fn(a, b) { a + b }}; h.add(1, 2)`, 3},
+		{`let counter = {"n": 5, "get": fn() { self.n }}; counter.get()`, 5},
+		{`let h = {"n": 2, "scale": fn(x) { x * self.n }}; h.scale(21)`, 42},
+		{`let h = {"len": len}; h.len([1, 2, 3])`, 3},
+		{`struct Counter { n, inc }; let c = Counter(0, fn(by) { self.n = self.n + by }); c.inc(2); c.inc(3); c.n`, 5},
+		{`let o = {"inner": {"v": 7, "get": fn() { self.v }}}; o.inner.get()`, 7},
+		{`let a = {"x": 1, "f": fn() { self.x }}; let b = {"x": 2, "f": a.f}; b.f()`, 2},
+		{`let f = fn() { self }; f()`, nil},
+		{`let h = {"f": fn() { let g = fn() { self }; g() }}; h.f()`, nil},
+		{`self`, nil},
+		{`let self = 5; self`, 5},
+		{`let f = fn(self) { self * 2 }; f(3)`, 6},
+		{`let h = {"n": 1, "f": fn(self) { self }}; h.f(4)`, 4},
+		{`let h = {"n": 1, "f": fn() { let self = 9; self }}; h.f()`, 9},
+		{`let self = 5; let h = {"n": 1, "f": fn() { self.n }}; h.f()`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		integer, ok := tt.expected.(int)
+
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -328,6 +537,42 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"let f = fn(a, b) { a }; f(1)",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"fn() { 1 }(1)",
+			"wrong number of arguments: want=0, got=1",
+		},
+		{
+			"map([1, 2], fn(a, b) { a })",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"let f = fn(n) { 1 + f(n + 1) }; f(0)",
+			"execution limit exceeded: more than 65535 nested function calls",
+		},
+		{
+			"let [a] = 1; a",
+			"index operator not supported: INTEGER",
+		},
+		{
+			"struct P { x }; P(1, 2)",
+			"wrong number of arguments: want=1, got=2",
+		},
+		{
+			"struct P { x }; P(1).y",
+			"unknown field y on P",
+		},
+		{
+			"let a = [1]; a.x",
+			"field access not supported: ARRAY",
+		},
+		{
+			"let a = 1; a.x = 2",
+			"field assignment not supported: INTEGER",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
@@ -371,6 +616,14 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`{[1, fn(x) { x }]: 1}`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`struct P { x }; {P(1): 1}`,
+			"unusable as hash key: STRUCT is mutable",
+		},
 	}
 
 	for _, tt := range tests {
@@ -415,6 +668,69 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestEvalContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+
+	slow := `let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)`
+	doubling := `let d = fn(s, n) { if (n == 0) { s } else { d(s + s, n - 1) } }; d("ab", 40)`
+	pushing := `let p = fn(a, n) { if (n == 0) { a } else { p(push(a, n), n - 1) } }; p([], 1000)`
+	recursing := `let f = fn(n) { 1 + f(n + 1) }; f(0)`
+
+	tests := []struct {
+		ctx         context.Context
+		input       string
+		limits      evaluator.Limits
+		expectedErr string
+		contextErr  error
+	}{
+		{context.Background(), "1 + 2", evaluator.Limits{MaxSteps: 4}, "execution limit exceeded: more than 4 steps", nil},
+		{cancelled, "1 + 2", evaluator.Limits{}, "execution limit exceeded: context canceled", context.Canceled},
+		{timeout, slow, evaluator.Limits{}, "execution limit exceeded: context deadline exceeded", context.DeadlineExceeded},
+		{context.Background(), slow, evaluator.Limits{MaxSteps: 1000}, "execution limit exceeded: more than 1000 steps", nil},
+		{context.Background(), doubling, evaluator.Limits{MaxMemory: 1 << 20}, "execution limit exceeded: more than 1048576 bytes allocated", nil},
+		{context.Background(), pushing, evaluator.Limits{MaxMemory: 10000}, "execution limit exceeded: more than 10000 bytes allocated", nil},
+		{context.Background(), "[1, 2, 3]", evaluator.Limits{MaxMemory: 50}, "execution limit exceeded: more than 50 bytes allocated", nil},
+		{context.Background(), recursing, evaluator.Limits{}, "execution limit exceeded: more than 65535 nested function calls", nil},
+		{context.Background(), "let f = fn(x) { map([x], f) }; f(1)", evaluator.Limits{}, "execution limit exceeded: more than 65535 nested function calls", nil},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		result, err := evaluator.EvalContext(tt.ctx, parse(tt.input), env, tt.limits)
+		if err == nil {
+			t.Fatalf("expected error for %q but got result %v", tt.input, result)
+		}
+
+		if err.Error() != tt.expectedErr {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedErr, err)
+		}
+		if !errors.Is(err, object.ErrExecutionLimit) {
+			t.Errorf("error %q does not match object.ErrExecutionLimit", err)
+		}
+		if tt.contextErr != nil && !errors.Is(err, tt.contextErr) {
+			t.Errorf("error %q does not match %q", err, tt.contextErr)
+		}
+
+		// The limits only apply to the call they were passed to
+		testIntegerObject(t, evaluator.Eval(parse("1 + 2"), env), 3)
+	}
+
+	result, err := evaluator.EvalContext(context.Background(), parse("1 + 2"), object.NewEnvironment(), evaluator.Limits{MaxSteps: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 3)
+
+	result, err = evaluator.EvalContext(context.Background(), parse(`len(push([1, 2, 3], 4))`), object.NewEnvironment(), evaluator.Limits{MaxMemory: 200})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 4)
+}
+
 func TestIfElseExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -442,6 +758,48 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match 2 { 1 => 10, 2 => 20, _ => 30 }`, 20},
+		{`match 5 { 1 => 10 }`, nil},
+		{`match "b" { "a" => 1, "b" => 2 }`, 2},
+		{`match true { false => 0, true => 1 }`, 1},
+		{`match -3 { -3 => 1, _ => 0 }`, 1},
+		{`match 1 { "1" => 1, _ => 0 }`, 0},
+		{`match [1, 2, 3] { [] => 0, [h, ...t] => h + len(t) }`, 3},
+		{`match [1, 2] { [a, b, c] => 3, [a, b] => a + b }`, 3},
+		{`match [] { [h, ...t] => 1, [] => 0 }`, 0},
+		{`match [1] { [h, ...t] => len(t) }`, 0},
+		{`match [1, [2, 3]] { [a, [b, c]] => a + b + c }`, 6},
+		{`match {"type": "user", "id": 7} { {"type": "admin"} => 0, {"type": "user", "id": id} => id }`, 7},
+		{`match {"id": 1} { {"name": n} => n, _ => 99 }`, 99},
+		{`match 1 { {"id": id} => id, [x] => x, _ => 3 }`, 3},
+		{`match 10 { x if x > 5 => 1, x => 2 }`, 1},
+		{`match 3 { x if x > 5 => 1, x => 2 }`, 2},
+		{`match 3 { x if match x { 3 => false, _ => true } => 1, _ => 2 }`, 2},
+		{`let f = fn(xs) { match xs { [h, ...t] => h + len(t), _ => 0 } }; f([5, 6, 7]) + f(1)`, 7},
+		{`let a = 1; match 5 { a if a > 10 => 2, _ => a }`, 1},
+		{`let a = 1; match [5] { [a] => a }; a`, 1},
+		{`let f = fn() { let a = 1; match 5 { a => a } + a }; f()`, 6},
+		{`match 5 { x => match 6 { x => x } + x }`, 11},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		integer, ok := tt.expected.(int)
+
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -536,6 +894,10 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -568,3 +930,103 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 
 	return true
 }
+
+func TestHostObjects(t *testing.T) {
+	request := &object.HostType{
+		Name: "Request",
+		Methods: map[string]object.HostMethod{
+			"header": func(ctx *object.BuiltinContext, value interface{}, args ...object.Object) object.Object {
+				return &object.String{Value: value.(map[string]string)[args[0].Inspect()]}
+			},
+		},
+		Properties: map[string]object.HostProperty{
+			"path": func(value interface{}) object.Object {
+				return &object.String{Value: value.(map[string]string)["path"]}
+			},
+		},
+		Key: func(value interface{}) interface{} { return value.(map[string]string)["path"] },
+	}
+	registry := object.NewStandardRegistry()
+	registry.Register("request", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		return &object.HostObject{Def: request, Value: map[string]string{"path": args[0].Inspect(), "x": "1"}}
+	}})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`request("/a").header("x")`, "1"},
+		{`let r = request("/a"); r.path`, "/a"},
+		{`let h = request("/a").header; h("x") + h("y")`, "1"},
+		{`{request("/a"): "found"}[request("/a")]`, "found"},
+		{`request("/a")`, "<Request>"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := evaluator.Eval(parse(tt.input), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`request("/a").body`, "unknown field body on Request"},
+		{`request("/a").path = "/b"`, "field assignment not supported: HOST"},
+	}
+
+	for _, tt := range errorTests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := evaluator.Eval(parse(tt.input), env)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, evaluated)
+		}
+	}
+}
+
+func TestBuiltinIO(t *testing.T) {
+	var stdout bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetIO(&object.IO{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: io.Discard})
+
+	evaluator.Eval(parse(`let say = fn(x) { puts(x) }; say("hello"); puts([1, 2], 1 + 2)`), env)
+
+	if stdout.String() != "hello\n[1, 2]\n3\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "hello\n[1, 2]\n3\n", stdout.String())
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map([[1], [2, 3]], len)`, "[1, 2]"},
+		{`let n = 10; map([1, 2], fn(x) { x + n })`, "[11, 12]"},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, "[3, 4]"},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, "10"},
+		{`each([1, 2], fn(x) { x })`, "null"},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, "2"},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, "null"},
+		{`any([1, 2, 3], fn(x) { x == 2 })`, "true"},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, "false"},
+		{`let f = fn(x) { return x + 1; 0 }; map([1], f)`, "[2]"},
+		{`map(1, fn(x) { x })`, "ERROR: first argument to `map` must be ARRAY, got INTEGER"},
+		{`filter([1], 1)`, "ERROR: last argument to `filter` must be a function, got INTEGER"},
+		{`map([1], fn(x) { x + true }); 1`, "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
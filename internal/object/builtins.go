@@ -2,14 +2,15 @@ package object
 
 import "fmt"
 
-var Builtins = []struct {
+// standardBuiltins are the builtins of every registry created by NewStandardRegistry
+var standardBuiltins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -28,9 +29,9 @@ var Builtins = []struct {
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(ctx.IO.Stdout, arg.Inspect())
 				}
 
 				return nil
@@ -40,7 +41,7 @@ var Builtins = []struct {
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -60,7 +61,7 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -80,7 +81,7 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -91,6 +92,10 @@ var Builtins = []struct {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
+					if err := ctx.Budget.Allocate(ArraySize(length - 1)); err != nil {
+						return newError("%s", err)
+					}
+
 					newElements := make([]Object, length-1)
 					copy(newElements, arr.Elements[1:length])
 					return &Array{Elements: newElements}
@@ -103,7 +108,7 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
 				if len(args) != 2 {
 					return newError("wrong number of arguments. got=%d, want=2", len(args))
 				}
@@ -113,6 +118,9 @@ var Builtins = []struct {
 
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
+				if err := ctx.Budget.Allocate(ArraySize(length + 1)); err != nil {
+					return newError("%s", err)
+				}
 
 				newElements := make([]Object, length+1)
 				copy(newElements, arr.Elements)
@@ -122,18 +130,251 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"map",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("map", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+				if err := ctx.Budget.Allocate(ArraySize(len(arr.Elements))); err != nil {
+					return newError("%s", err)
+				}
+
+				newElements := make([]Object, len(arr.Elements))
+				for i, e := range arr.Elements {
+					result, err := ctx.Call(fn, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					newElements[i] = result
+				}
+
+				return &Array{Elements: newElements}
+			},
+		},
+	},
+	{
+		"filter",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("filter", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				newElements := []Object{}
+				for _, e := range arr.Elements {
+					result, err := ctx.Call(fn, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					if isTruthy(result) {
+						newElements = append(newElements, e)
+					}
+				}
+				if err := ctx.Budget.Allocate(ArraySize(len(newElements))); err != nil {
+					return newError("%s", err)
+				}
+
+				return &Array{Elements: newElements}
+			},
+		},
+	},
+	{
+		"reduce",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("reduce", 3, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				acc := args[1]
+				for _, e := range arr.Elements {
+					result, err := ctx.Call(fn, acc, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					acc = result
+				}
+
+				return acc
+			},
+		},
+	},
+	{
+		"each",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("each", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				for _, e := range arr.Elements {
+					if _, err := ctx.Call(fn, e); err != nil {
+						return newError("%s", err)
+					}
+				}
+
+				return nil
+			},
+		},
+	},
+	{
+		"find",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("find", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				for _, e := range arr.Elements {
+					result, err := ctx.Call(fn, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					if isTruthy(result) {
+						return e
+					}
+				}
+
+				return nil
+			},
+		},
+	},
+	{
+		"any",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("any", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				for _, e := range arr.Elements {
+					result, err := ctx.Call(fn, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					if isTruthy(result) {
+						return TRUE
+					}
+				}
+
+				return FALSE
+			},
+		},
+	},
+	{
+		"all",
+		&Builtin{
+			Fn: func(ctx *BuiltinContext, args ...Object) Object {
+				arr, fn, errObj := arrayAndFunction("all", 2, args)
+				if errObj != nil {
+					return errObj
+				}
+
+				for _, e := range arr.Elements {
+					result, err := ctx.Call(fn, e)
+					if err != nil {
+						return newError("%s", err)
+					}
+					if !isTruthy(result) {
+						return FALSE
+					}
+				}
+
+				return TRUE
+			},
+		},
+	},
+}
+
+// arrayAndFunction checks the arguments of the higher-order builtin name, which takes an
+// array first, a function last and want arguments in all
+func arrayAndFunction(name string, want int, args []Object) (*Array, Object, *Error) {
+	if len(args) != want {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, newError("first argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	fn := args[want-1]
+	switch fn.Type() {
+	case FUNCTION_OBJ, COMPILED_FUNCTION_OBJ, BUILTIN_OBJ, STRUCT_TYPE_OBJ:
+		return arr, fn, nil
+	default:
+		return nil, nil, newError("last argument to `%s` must be a function, got %s", name, fn.Type())
+	}
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
-		}
+// isTruthy reports whether obj counts as true in a condition: everything but false and
+// null does
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
 	}
+}
 
+// Registry is a set of builtins, looked up by name. A compiled program records the names
+// of the builtins it uses rather than their place in a registry, so a registry can be
+// extended or replaced by one with different builtins without recompiling programs.
+type Registry struct {
+	builtins map[string]*Builtin
+	names    []string
+}
+
+// NewRegistry creates a registry without any builtins
+func NewRegistry() *Registry {
+	return &Registry{builtins: map[string]*Builtin{}}
+}
+
+// NewStandardRegistry creates a registry with the standard builtins: len, puts, first,
+// last, rest, push, map, filter, reduce, each, find, any and all
+func NewStandardRegistry() *Registry {
+	r := NewRegistry()
+	for _, def := range standardBuiltins {
+		r.Register(def.Name, def.Builtin)
+	}
+
+	return r
+}
+
+// Register adds builtin to the registry under name. It returns an error if the registry
+// already has a builtin with that name.
+func (r *Registry) Register(name string, builtin *Builtin) error {
+	if _, ok := r.builtins[name]; ok {
+		return fmt.Errorf("builtin %q is already defined", name)
+	}
+
+	r.builtins[name] = builtin
+	r.names = append(r.names, name)
 	return nil
 }
 
+// Lookup returns the builtin registered under name
+func (r *Registry) Lookup(name string) (*Builtin, bool) {
+	builtin, ok := r.builtins[name]
+	return builtin, ok
+}
+
+// Names returns the names of the builtins in the order they were registered
+func (r *Registry) Names() []string {
+	return append([]string{}, r.names...)
+}
+
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
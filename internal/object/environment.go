@@ -14,6 +14,18 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// budget limits the evaluation running in the environment. It is only set on the
+	// outermost environment.
+	budget *Budget
+	// builtins are the builtins identifiers that are not bound refer to. They are only
+	// set on the outermost environment.
+	builtins *Registry
+	// io is where builtins read input and write output. It is only set on the
+	// outermost environment.
+	io *IO
+	// calls is the number of function calls in progress in the evaluation running in
+	// the environment. It is only kept on the outermost environment.
+	calls int
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -28,3 +40,80 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Budget returns the budget of the evaluation running in the environment, or nil if it
+// runs without limits
+func (e *Environment) Budget() *Budget {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.budget
+}
+
+// SetBudget sets the budget of evaluations running in the environment and every
+// environment enclosed in it, and returns the budget it replaces
+func (e *Environment) SetBudget(b *Budget) *Budget {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	previous := e.budget
+	e.budget = b
+	return previous
+}
+
+// Builtins returns the builtins of the environment, or nil if it uses the standard
+// builtins
+func (e *Environment) Builtins() *Registry {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.builtins
+}
+
+// SetBuiltins sets the builtins of the environment and every environment enclosed in it
+func (e *Environment) SetBuiltins(r *Registry) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.builtins = r
+}
+
+// IO returns the streams the builtins of the environment use, which are the standard
+// streams of the process unless SetIO was called
+func (e *Environment) IO() *IO {
+	for e.outer != nil {
+		e = e.outer
+	}
+	if e.io == nil {
+		return StandardIO()
+	}
+	return e.io
+}
+
+// SetIO sets the streams the builtins of the environment and every environment enclosed
+// in it use
+func (e *Environment) SetIO(io *IO) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.io = io
+}
+
+// EnterCall counts a function call starting in the evaluation running in the
+// environment and returns the number of calls in progress, including it
+func (e *Environment) EnterCall() int {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.calls++
+	return e.calls
+}
+
+// LeaveCall counts a function call started with EnterCall returning
+func (e *Environment) LeaveCall() {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.calls--
+}
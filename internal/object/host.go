@@ -0,0 +1,74 @@
+package object
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// HostMethod implements a method of a HostType. It is called with the Go value wrapped
+// by the receiver and the arguments of the call.
+type HostMethod func(ctx *BuiltinContext, value interface{}, args ...Object) Object
+
+// HostProperty returns the value of a property of a HostType for the Go value wrapped by
+// the object it is read from
+type HostProperty func(value interface{}) Object
+
+// HostType describes a kind of HostObject: the methods programs can call on its values
+// and the properties they can read. Programs can not assign to the fields of a host
+// object.
+type HostType struct {
+	Name       string
+	Methods    map[string]HostMethod
+	Properties map[string]HostProperty
+	// Inspect returns the text of a wrapped value. Values are shown as the name of the
+	// type in angle brackets when it is nil.
+	Inspect func(value interface{}) string
+	// Key makes values usable as hash keys when it is set. Two values are the same key
+	// when Key returns equal values for them, so it must return a comparable value such
+	// as a string or an ID. AsHashable rejects values whose key is not comparable.
+	Key func(value interface{}) interface{}
+}
+
+// HostObject is an opaque handle to a Go value, such as a database row or a request,
+// whose methods and properties are implemented by the host
+type HostObject struct {
+	Def   *HostType
+	Value interface{}
+}
+
+func (h *HostObject) Type() ObjectType { return HOST_OBJ }
+func (h *HostObject) Inspect() string {
+	if h.Def.Inspect == nil {
+		return "<" + h.Def.Name + ">"
+	}
+
+	return h.Def.Inspect(h.Value)
+}
+
+// HashKey hashes the key of the wrapped value. It is only used once AsHashable has
+// checked that the type has a Key.
+func (h *HostObject) HashKey() HashKey {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s:%T:%v", h.Def.Name, h.Def.Key(h.Value), h.Def.Key(h.Value))
+
+	return HashKey{Type: h.Type(), Value: hash.Sum64()}
+}
+
+// Field returns what name refers to on h: the value of a property, or a method bound to
+// the wrapped value that is called like a builtin
+func (h *HostObject) Field(name string) (Object, bool) {
+	if property, ok := h.Def.Properties[name]; ok {
+		if value := property(h.Value); value != nil {
+			return value, true
+		}
+		return NULL, true
+	}
+
+	if method, ok := h.Def.Methods[name]; ok {
+		return &Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			return method(ctx, h.Value, args...)
+		}}, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,156 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrExecutionLimit matches, using errors.Is, every error reporting that a program was
+// stopped before it finished by one of its execution limits
+var ErrExecutionLimit = errors.New("execution limit exceeded")
+
+// limitCheckInterval is the number of steps between checks of a context
+const limitCheckInterval = 1024
+
+// LimitError reports that a program was stopped because it exceeded an execution limit
+// or because the context it ran under was cancelled or passed its deadline
+type LimitError struct {
+	// Reason describes the limit that was exceeded
+	Reason string
+	// Err is the error of the context when it stopped the program
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrExecutionLimit, e.Reason)
+}
+
+// Unwrap returns the error of the context that stopped the program, if any
+func (e *LimitError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrExecutionLimit
+func (e *LimitError) Is(target error) bool { return target == ErrExecutionLimit }
+
+// ContextLimitError returns the error for a program stopped because ctx is done
+func ContextLimitError(ctx context.Context) *LimitError {
+	return &LimitError{Reason: ctx.Err().Error(), Err: ctx.Err()}
+}
+
+// CountLimitError returns the error for a program that went over a maximum count of
+// something, such as instructions executed
+func CountLimitError(max int, unit string) *LimitError {
+	return &LimitError{Reason: fmt.Sprintf("more than %d %s", max, unit)}
+}
+
+// Approximate sizes, in bytes, of the memory taken up by objects. They are used to account
+// for the memory a program allocates.
+const (
+	// ObjectSize is the size of an object without elements, such as an integer
+	ObjectSize = 16
+	// ReferenceSize is the size of a reference to an object stored in an array or struct
+	ReferenceSize = 16
+	// HashPairSize is the size of a pair stored in a hash, including its bookkeeping
+	HashPairSize = 64
+)
+
+// ArraySize returns the size of an array with n elements. The elements themselves are
+// accounted for when they are created.
+func ArraySize(n int) int { return ObjectSize + n*ReferenceSize }
+
+// StringSize returns the size of a string of n bytes
+func StringSize(n int) int { return ObjectSize + n }
+
+// HashSize returns the size of a hash with n pairs
+func HashSize(n int) int { return ObjectSize + n*HashPairSize }
+
+// Budget counts the steps an evaluation takes and the memory it allocates against its
+// limits. The memory allocated is the total of every allocation, including those that
+// are no longer in use, so it bounds the work a program does as well as its size.
+type Budget struct {
+	ctx       context.Context
+	maxSteps  int
+	steps     int
+	maxMemory int
+	allocated int
+	err       *LimitError
+}
+
+// NewBudget returns a budget that runs out when ctx is done, after maxSteps steps if
+// maxSteps is positive, or once more than maxMemory bytes were allocated if maxMemory
+// is positive
+func NewBudget(ctx context.Context, maxSteps, maxMemory int) *Budget {
+	return &Budget{ctx: ctx, maxSteps: maxSteps, maxMemory: maxMemory}
+}
+
+// Step counts one step. It returns a LimitError once the budget has run out, and keeps
+// returning it for every later step.
+func (b *Budget) Step() error {
+	if b.err != nil {
+		return b.err
+	}
+
+	b.steps++
+	switch {
+	case b.maxSteps > 0 && b.steps > b.maxSteps:
+		b.err = CountLimitError(b.maxSteps, "steps")
+	case (b.steps-1)%limitCheckInterval == 0 && b.ctx.Err() != nil:
+		b.err = ContextLimitError(b.ctx)
+	default:
+		return nil
+	}
+
+	return b.err
+}
+
+// Allocate accounts for size bytes of memory about to be allocated. It returns a
+// LimitError once the budget has run out, and keeps returning it for every later
+// allocation. A nil budget has no limits.
+func (b *Budget) Allocate(size int) error {
+	if b == nil || b.maxMemory <= 0 {
+		return b.Err()
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	b.allocated += size
+	if b.allocated > b.maxMemory {
+		b.err = CountLimitError(b.maxMemory, "bytes allocated")
+		return b.err
+	}
+
+	return nil
+}
+
+// Stop runs the budget out with err unless it has run out already, and returns the
+// LimitError it ran out with. A nil budget returns err.
+func (b *Budget) Stop(err *LimitError) error {
+	if b == nil {
+		return err
+	}
+	if b.err == nil {
+		b.err = err
+	}
+
+	return b.err
+}
+
+// Context returns the context the budget runs out with, or context.Background() if the
+// budget is nil
+func (b *Budget) Context() context.Context {
+	if b == nil {
+		return context.Background()
+	}
+
+	return b.ctx
+}
+
+// Err returns the LimitError the budget ran out with, or nil if it has not run out or is nil
+func (b *Budget) Err() error {
+	if b == nil || b.err == nil {
+		return nil
+	}
+
+	return b.err
+}
@@ -2,8 +2,12 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"hash/fnv"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/ast"
@@ -22,6 +26,11 @@ const (
 	ARRAY_OBJ             = "ARRAY"
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	STRUCT_TYPE_OBJ       = "STRUCT_TYPE"
+	STRUCT_OBJ            = "STRUCT"
+	HOST_OBJ              = "HOST"
+	FIELD_NAME_OBJ        = "FIELD_NAME"
+	MATCH_TABLE_OBJ       = "MATCH_TABLE"
 )
 
 type (
@@ -30,7 +39,7 @@ type (
 		Type() ObjectType
 		Inspect() string
 	}
-	BuiltinFunction func(args ...Object) Object
+	BuiltinFunction func(ctx *BuiltinContext, args ...Object) Object
 	HashKey         struct {
 		Type  ObjectType
 		Value uint64
@@ -40,7 +49,9 @@ type (
 		Value Object
 	}
 
+	// Hashable is implemented by objects that can be used as hash keys
 	Hashable interface {
+		Object
 		HashKey() HashKey
 	}
 
@@ -56,8 +67,196 @@ func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
+// Hash is a hash table that remembers the order its keys were first inserted in.
+// Keys are bucketed by their HashKey. Keys that share a HashKey are told apart by
+// comparing them, so colliding keys never overwrite each other.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	// buckets maps a HashKey to the positions in pairs of every key with that HashKey
+	buckets map[HashKey][]int
+	pairs   []HashPair
+}
+
+// NewHash creates an empty Hash
+func NewHash() *Hash {
+	return &Hash{buckets: make(map[HashKey][]int)}
+}
+
+// find returns the HashKey of key and the position of its pair, or -1 if the hash
+// does not contain key
+func (h *Hash) find(key Hashable) (HashKey, int) {
+	hashKey := key.HashKey()
+	for _, i := range h.buckets[hashKey] {
+		if keysEqual(h.pairs[i].Key, key) {
+			return hashKey, i
+		}
+	}
+
+	return hashKey, -1
+}
+
+// Set stores value under key. A new key is placed after every existing key, while
+// overwriting an existing key keeps its original position.
+func (h *Hash) Set(key Hashable, value Object) {
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+
+	hashKey, i := h.find(key)
+	if i >= 0 {
+		h.pairs[i].Value = value
+		return
+	}
+
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Get returns the value stored under key and whether the hash contains key
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	_, i := h.find(key)
+	if i < 0 {
+		return nil, false
+	}
+
+	return h.pairs[i].Value, true
+}
+
+// Len returns the number of pairs in the hash
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// OrderedPairs returns the pairs of the hash in insertion order.
+// The returned slice must not be modified.
+func (h *Hash) OrderedPairs() []HashPair {
+	return h.pairs
+}
+
+// keysEqual reports whether two hash keys have the same type and value
+func keysEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !keysEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.pairs {
+			value, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !keysEqual(pair.Value, value) {
+				return false
+			}
+		}
+		return true
+	case *HostObject:
+		b, ok := b.(*HostObject)
+		return ok && a.Def == b.Def && a.Def.Key(a.Value) == b.Def.Key(b.Value)
+	default:
+		return false
+	}
+}
+
+// AsHashable returns obj as a Hashable, or an error if obj cannot be used as a hash key.
+// Arrays and hashes are hashed by their contents, so they are only usable as keys
+// when every value they contain is. Structs are never usable as keys because their
+// fields can be reassigned, which would change their hash after insertion.
+func AsHashable(obj Object) (Hashable, error) {
+	switch obj := obj.(type) {
+	case *Struct:
+		return nil, fmt.Errorf("unusable as hash key: %s is mutable", obj.Type())
+	case *HostObject:
+		if obj.Def.Key == nil {
+			return nil, fmt.Errorf("unusable as hash key: %s", obj.Def.Name)
+		}
+		// Keys are compared with ==, which panics for slices, maps and functions
+		if key := obj.Def.Key(obj.Value); !isComparable(key) {
+			return nil, fmt.Errorf("unusable as hash key: %s has a key of type %T, which is not comparable", obj.Def.Name, key)
+		}
+	case *Array:
+		for _, e := range obj.Elements {
+			if _, err := AsHashable(e); err != nil {
+				return nil, err
+			}
+		}
+	case *Hash:
+		for _, pair := range obj.pairs {
+			if _, err := AsHashable(pair.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return nil, fmt.Errorf("unusable as hash key: %s", obj.Type())
+	}
+
+	return hashable, nil
+}
+
+// isComparable reports whether key can be compared with ==. A struct or array key is
+// only comparable if the values in its fields or elements are.
+func isComparable(key interface{}) (comparable bool) {
+	defer func() {
+		if recover() != nil {
+			comparable = false
+		}
+	}()
+
+	_ = key == key
+	return true
+}
+
+// writeHashKey feeds k into h
+func writeHashKey(h hash.Hash64, k HashKey) {
+	var buf [8]byte
+
+	h.Write([]byte(k.Type))
+	binary.LittleEndian.PutUint64(buf[:], k.Value)
+	h.Write(buf[:])
+}
+
+// contentHashKey returns the HashKey of obj, or a key identifying only its type when
+// obj is not hashable. Callers are expected to reject such values with AsHashable.
+func contentHashKey(obj Object) HashKey {
+	if hashable, ok := obj.(Hashable); ok {
+		return hashable.HashKey()
+	}
+
+	return HashKey{Type: obj.Type()}
+}
+
+// HashKey hashes every pair independently and sums the results, so two hashes with
+// the same pairs have the same key regardless of insertion order
+func (h *Hash) HashKey() HashKey {
+	var value uint64
+	for _, pair := range h.pairs {
+		pairHash := fnv.New64a()
+		writeHashKey(pairHash, contentHashKey(pair.Key))
+		writeHashKey(pairHash, contentHashKey(pair.Value))
+		value += pairHash.Sum64()
+	}
+
+	return HashKey{Type: h.Type(), Value: value}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -65,7 +264,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -76,11 +275,103 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// StructType is a user-defined record type declared with `struct Name { fields }`.
+// Calling it with one argument per field constructs a Struct.
+type StructType struct {
+	Name   string
+	Fields []string
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (st *StructType) Inspect() string {
+	return fmt.Sprintf("struct %s { %s }", st.Name, strings.Join(st.Fields, ", "))
+}
+
+// FieldIndex returns the slot the named field is stored in
+func (st *StructType) FieldIndex(name string) (int, bool) {
+	for i, f := range st.Fields {
+		if f == name {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
+// FieldName is the constant naming the field an instruction reads, assigns or calls.
+// Every instruction gets a FieldName of its own, rather than sharing one per name, so
+// the VM can cache the slot it found the field in for each place fields are accessed.
+type FieldName struct {
+	Name string
+}
+
+func (f *FieldName) Type() ObjectType { return FIELD_NAME_OBJ }
+func (f *FieldName) Inspect() string  { return f.Name }
+
+// MatchTable is the constant an OpMatchTable instruction dispatches on. It maps every
+// integer and string literal the leading arms of a match test for to the number of the
+// first arm testing for it.
+type MatchTable struct {
+	Integers map[int64]int
+	Strings  map[string]int
+}
+
+func (t *MatchTable) Type() ObjectType { return MATCH_TABLE_OBJ }
+func (t *MatchTable) Inspect() string {
+	return fmt.Sprintf("MatchTable[%d cases]", len(t.Integers)+len(t.Strings))
+}
+
+// Case returns the number of the arm that value selects, and false if no arm tests for it
+func (t *MatchTable) Case(value Object) (int, bool) {
+	var arm int
+	var ok bool
+	switch value := value.(type) {
+	case *Integer:
+		arm, ok = t.Integers[value.Value]
+	case *String:
+		arm, ok = t.Strings[value.Value]
+	}
+
+	return arm, ok
+}
+
+// Struct is an instance of a StructType. Its field values are stored by slot,
+// in the order the fields were declared.
+type Struct struct {
+	Def    *StructType
+	Fields []Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for i, name := range s.Def.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, s.Fields[i].Inspect()))
+	}
+
+	out.WriteString(s.Def.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
 type Array struct {
 	Elements []Object
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, e := range ao.Elements {
+		writeHashKey(h, contentHashKey(e))
+	}
+
+	return HashKey{Type: ao.Type(), Value: h.Sum64()}
+}
 func (ao *Array) Inspect() string {
 	var out bytes.Buffer
 
@@ -100,6 +391,31 @@ type Builtin struct {
 	Fn BuiltinFunction
 }
 
+// BuiltinContext gives a builtin access to the execution calling it
+type BuiltinContext struct {
+	// Budget accounts for the memory the builtin allocates. It is nil when the execution
+	// runs without limits.
+	Budget *Budget
+	// IO is where the builtin reads input and writes output
+	IO *IO
+	// Call calls fn, a function of the program passed to the builtin, with args. A call
+	// that fails stops the program, so the builtin should return the error straight away.
+	Call func(fn Object, args ...Object) (Object, error)
+}
+
+// IO holds the streams an execution's builtins read input from and write output to, so
+// that output can be captured or kept apart per execution
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// StandardIO returns the standard streams of the process
+func StandardIO() *IO {
+	return &IO{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
@@ -110,10 +426,16 @@ type String struct {
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Value: HashString(s.Value)}
+}
+
+// HashString hashes the value of a String for its HashKey.
+// It is a variable so tests can swap in a hasher that forces collisions.
+var HashString = func(s string) uint64 {
 	h := fnv.New64a()
-	h.Write([]byte(s.Value))
+	h.Write([]byte(s))
 
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	return h.Sum64()
 }
 
 type Integer struct {
@@ -130,6 +452,22 @@ type Boolean struct {
 	Value bool
 }
 
+// TRUE and FALSE are the only Boolean values the engines create. Booleans are compared
+// by identity, so Go code handing a Boolean to a program must use one of them.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// NativeBool returns the Boolean for b
+func NativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+
+	return FALSE
+}
+
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) HashKey() HashKey {
@@ -146,6 +484,10 @@ func (b *Boolean) HashKey() HashKey {
 
 type Null struct{}
 
+// NULL is the only Null value the engines create. Like booleans, null is compared by
+// identity.
+var NULL = &Null{}
+
 func (n *Null) Inspect() string  { return "null" }
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 
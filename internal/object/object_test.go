@@ -21,3 +21,141 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have the same hash keys")
 	}
 }
+
+func TestCompositeHashKey(t *testing.T) {
+	array1 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	array2 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	swapped := &Array{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+
+	if array1.HashKey() != array2.HashKey() {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+
+	if array1.HashKey() == swapped.HashKey() {
+		t.Errorf("arrays with different content have same hash keys")
+	}
+
+	hash1 := NewHash()
+	hash1.Set(&String{Value: "a"}, &Integer{Value: 1})
+	hash1.Set(&String{Value: "b"}, &Integer{Value: 2})
+	hash2 := NewHash()
+	hash2.Set(&String{Value: "b"}, &Integer{Value: 2})
+	hash2.Set(&String{Value: "a"}, &Integer{Value: 1})
+
+	if hash1.HashKey() != hash2.HashKey() || !keysEqual(hash1, hash2) {
+		t.Errorf("hashes with same pairs are not equal keys")
+	}
+
+	point := &Struct{Def: &StructType{Name: "P", Fields: []string{"x"}}, Fields: []Object{&Integer{Value: 1}}}
+	_, err := AsHashable(&Array{Elements: []Object{point}})
+	if err == nil || err.Error() != "unusable as hash key: STRUCT is mutable" {
+		t.Errorf("wrong error for array containing struct. got=%v", err)
+	}
+}
+
+func TestHostObjectHashKey(t *testing.T) {
+	type row struct {
+		id   int
+		tags []string
+	}
+	keyed := func(key func(value interface{}) interface{}) *HostObject {
+		return &HostObject{Def: &HostType{Name: "Row", Key: key}, Value: row{id: 1, tags: []string{"a"}}}
+	}
+
+	id := keyed(func(value interface{}) interface{} { return value.(row).id })
+	if _, err := AsHashable(id); err != nil {
+		t.Errorf("unexpected error for a comparable key: %s", err)
+	}
+
+	tests := []struct {
+		obj      *HostObject
+		expected string
+	}{
+		{keyed(func(value interface{}) interface{} { return value.(row).tags }), "unusable as hash key: Row has a key of type []string, which is not comparable"},
+		{keyed(func(value interface{}) interface{} { return value }), "unusable as hash key: Row has a key of type object.row, which is not comparable"},
+		{&HostObject{Def: &HostType{Name: "Row"}}, "unusable as hash key: Row"},
+	}
+
+	for _, tt := range tests {
+		_, err := AsHashable(&Array{Elements: []Object{tt.obj}})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestStructInspect(t *testing.T) {
+	def := &StructType{Name: "Point", Fields: []string{"y", "x"}}
+	point := &Struct{Def: def, Fields: []Object{&Integer{Value: 2}, &Integer{Value: 1}}}
+
+	if def.Inspect() != "struct Point { y, x }" {
+		t.Errorf("struct type Inspect wrong. got=%q", def.Inspect())
+	}
+
+	if point.Inspect() != "Point{y: 2, x: 1}" {
+		t.Errorf("struct Inspect wrong. got=%q", point.Inspect())
+	}
+
+	if slot, ok := def.FieldIndex("x"); !ok || slot != 1 {
+		t.Errorf("FieldIndex wrong. got=%d, %t", slot, ok)
+	}
+}
+
+func TestHashInsertionOrder(t *testing.T) {
+	hash := NewHash()
+
+	keys := []*String{{Value: "b"}, {Value: "a"}, {Value: "c"}, {Value: "b"}}
+	for i, k := range keys {
+		hash.Set(k, &Integer{Value: int64(i)})
+	}
+
+	expected := "{b: 3, a: 1, c: 2}"
+	if hash.Inspect() != expected {
+		t.Errorf("hash.Inspect() wrong. want=%q, got=%q", expected, hash.Inspect())
+	}
+
+	if len(hash.OrderedPairs()) != 3 {
+		t.Errorf("hash has wrong number of pairs. want=3, got=%d", len(hash.OrderedPairs()))
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	original := HashString
+	HashString = func(string) uint64 { return 1 }
+	defer func() { HashString = original }()
+
+	hash := NewHash()
+	hash.Set(&String{Value: "a"}, &Integer{Value: 1})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 2})
+	hash.Set(&Integer{Value: 1}, &Integer{Value: 3})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 4})
+
+	if hash.Len() != 3 {
+		t.Fatalf("hash has wrong number of pairs. want=3, got=%d", hash.Len())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{&String{Value: "a"}, 4},
+		{&String{Value: "b"}, 2},
+		{&Integer{Value: 1}, 3},
+	}
+
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if !ok {
+			t.Errorf("no value for key %s", tt.key.Inspect())
+			continue
+		}
+
+		if value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value for key %s. want=%d, got=%s", tt.key.Inspect(), tt.expected, value.Inspect())
+		}
+	}
+
+	if _, ok := hash.Get(&String{Value: "c"}); ok {
+		t.Errorf("colliding key that was never set was found")
+	}
+}
@@ -0,0 +1,171 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/ast"
+	"github.com/JosueMolinaMorales/orionlang/internal/token"
+)
+
+// Warning describes a problem in a program that does not stop it from running
+type Warning struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s", w.Line, w.Column, w.Message)
+}
+
+// EliminateDeadCode removes the statements of every block that can never run because
+// an earlier statement of the block always returns. It returns a warning locating the
+// first removed statement of each block, in source order. The tree is rewritten in place.
+func EliminateDeadCode(node ast.Node) []Warning {
+	warnings := []Warning{}
+
+	inspect(node, func(n ast.Node) {
+		var statements *[]ast.Statement
+		switch n := n.(type) {
+		case *ast.Program:
+			statements = &n.Statements
+		case *ast.BlockStatement:
+			statements = &n.Statements
+		default:
+			return
+		}
+
+		for i, stmt := range *statements {
+			if alwaysReturns(stmt) && i < len(*statements)-1 {
+				tok := statementToken((*statements)[i+1])
+				warnings = append(warnings, Warning{Line: tok.Line, Column: tok.Column, Message: "unreachable code"})
+
+				*statements = (*statements)[:i+1]
+				return
+			}
+		}
+	})
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Line != warnings[j].Line {
+			return warnings[i].Line < warnings[j].Line
+		}
+		return warnings[i].Column < warnings[j].Column
+	})
+
+	return warnings
+}
+
+// alwaysReturns reports whether running stmt always ends in a return statement
+func alwaysReturns(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return blockAlwaysReturns(stmt)
+	case *ast.ExpressionStatement:
+		ifExp, ok := stmt.Expression.(*ast.IfExpression)
+		if !ok || ifExp.Alternative == nil {
+			return false
+		}
+		return blockAlwaysReturns(ifExp.Consequence) && blockAlwaysReturns(ifExp.Alternative)
+	default:
+		return false
+	}
+}
+
+func blockAlwaysReturns(block *ast.BlockStatement) bool {
+	for _, stmt := range block.Statements {
+		if alwaysReturns(stmt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statementToken returns the token a statement starts at
+func statementToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	case *ast.BlockStatement:
+		return stmt.Token
+	case *ast.StructStatement:
+		return stmt.Token
+	default:
+		return token.Token{}
+	}
+}
+
+// inspect calls f for node and then for every node nested in it, depth first.
+// Nil nodes, such as a missing match guard, are skipped.
+func inspect(node ast.Node, f func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	f(node)
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			inspect(s, f)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			inspect(s, f)
+		}
+	case *ast.ExpressionStatement:
+		inspect(node.Expression, f)
+	case *ast.LetStatement:
+		inspect(node.Value, f)
+	case *ast.ReturnStatement:
+		inspect(node.ReturnValue, f)
+	case *ast.PrefixExpression:
+		inspect(node.Right, f)
+	case *ast.InfixExpression:
+		inspect(node.Left, f)
+		inspect(node.Right, f)
+	case *ast.IfExpression:
+		inspect(node.Condition, f)
+		inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			inspect(node.Alternative, f)
+		}
+	case *ast.FunctionLiteral:
+		inspect(node.Body, f)
+	case *ast.CallExpression:
+		inspect(node.Function, f)
+		for _, a := range node.Arguments {
+			inspect(a, f)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			inspect(el, f)
+		}
+	case *ast.HashLiteral:
+		for _, k := range node.Keys {
+			inspect(k, f)
+			inspect(node.Pairs[k], f)
+		}
+	case *ast.IndexExpression:
+		inspect(node.Left, f)
+		inspect(node.Index, f)
+	case *ast.FieldExpression:
+		inspect(node.Left, f)
+	case *ast.AssignExpression:
+		inspect(node.Target, f)
+		inspect(node.Value, f)
+	case *ast.MatchExpression:
+		inspect(node.Subject, f)
+		for _, arm := range node.Arms {
+			inspect(arm.Guard, f)
+			inspect(arm.Body, f)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/code"
+	"github.com/JosueMolinaMorales/orionlang/internal/compiler"
+	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/parser"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		input            string
+		expected         string
+		expectedWarnings []string
+	}{
+		{
+			"fn() { return 1; 2; 3 }",
+			"fn()return 1;",
+			[]string{"1:18: unreachable code"},
+		},
+		{
+			"fn(x) {\n  if (x) { return 1; } else { return 2; }\n  let y = 3;\n}",
+			"fn(x)ifx return 1;else return 2;",
+			[]string{"3:3: unreachable code"},
+		},
+		{
+			"fn(x) { if (x) { return 1; } x }",
+			"fn(x)ifx return 1;x",
+			[]string{},
+		},
+		{
+			"fn() { fn() { return 1; 2 }; return 3; 4 }",
+			"fn()fn()return 1;return 3;",
+			[]string{"1:25: unreachable code", "1:40: unreachable code"},
+		},
+		{
+			"let f = fn() { 1 }; f()",
+			"let f = fn()1;f()",
+			[]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		warnings := EliminateDeadCode(program)
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+
+		if len(warnings) != len(tt.expectedWarnings) {
+			t.Fatalf("wrong number of warnings for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expectedWarnings), len(warnings), warnings)
+		}
+
+		for i, w := range warnings {
+			if w.String() != tt.expectedWarnings[i] {
+				t.Errorf("wrong warning for %q. want=%q, got=%q", tt.input, tt.expectedWarnings[i], w.String())
+			}
+		}
+	}
+}
+
+func TestEliminateDeadCodeBytecode(t *testing.T) {
+	program := parser.New(lexer.New("fn() { return 1; 2; }")).ParseProgram()
+	EliminateDeadCode(program)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := comp.Bytecode().Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 is not a CompiledFunction. got=%T", comp.Bytecode().Constants[1])
+	}
+
+	expected := concat([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpReturnValue),
+	})
+	if fn.Instructions.String() != expected.String() {
+		t.Errorf("wrong instructions.\nwant=%q\ngot=%q", expected.String(), fn.Instructions.String())
+	}
+}
@@ -0,0 +1,268 @@
+package optimizer
+
+import (
+	"strconv"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/ast"
+	"github.com/JosueMolinaMorales/orionlang/internal/token"
+)
+
+// Fold rewrites the AST rooted at node, replacing every operation on literals with the
+// literal it evaluates to and pruning if branches whose condition is a constant.
+// Operations are only folded when both engines agree on their result, so folding never
+// changes what a program does. The tree is rewritten in place and the new root returned.
+func Fold(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.Program:
+		node.Statements = foldStatements(node.Statements)
+	case *ast.BlockStatement:
+		node.Statements = foldStatements(node.Statements)
+	case *ast.ExpressionStatement:
+		node.Expression = foldExpression(node.Expression)
+	case *ast.LetStatement:
+		node.Value = foldExpression(node.Value)
+	case *ast.ReturnStatement:
+		node.ReturnValue = foldExpression(node.ReturnValue)
+	case ast.Expression:
+		return foldExpression(node)
+	}
+
+	return node
+}
+
+// foldStatements folds every statement in stmts. An if statement whose condition is a
+// constant is replaced by the statements of the branch that would run.
+func foldStatements(stmts []ast.Statement) []ast.Statement {
+	folded := []ast.Statement{}
+	for i, stmt := range stmts {
+		stmt = Fold(stmt).(ast.Statement)
+
+		es, ok := stmt.(*ast.ExpressionStatement)
+		if !ok {
+			folded = append(folded, stmt)
+			continue
+		}
+
+		ifExp, ok := es.Expression.(*ast.IfExpression)
+		if !ok {
+			folded = append(folded, stmt)
+			continue
+		}
+
+		truthy, ok := constantTruthiness(ifExp.Condition)
+		if !ok {
+			folded = append(folded, stmt)
+			continue
+		}
+
+		branch := ifExp.Alternative
+		if truthy {
+			branch = ifExp.Consequence
+		}
+
+		switch {
+		case branch != nil && len(branch.Statements) > 0:
+			folded = append(folded, branch.Statements...)
+		case i < len(stmts)-1:
+			// Nothing would run, and the null it evaluates to is never used
+		default:
+			folded = append(folded, stmt)
+		}
+	}
+
+	return folded
+}
+
+// foldExpression folds exp and every expression nested in it
+func foldExpression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = foldExpression(exp.Right)
+		if folded := foldPrefix(exp); folded != nil {
+			return folded
+		}
+	case *ast.InfixExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Right = foldExpression(exp.Right)
+		if folded := foldInfix(exp); folded != nil {
+			return folded
+		}
+	case *ast.IfExpression:
+		exp.Condition = foldExpression(exp.Condition)
+		exp.Consequence = Fold(exp.Consequence).(*ast.BlockStatement)
+		if exp.Alternative != nil {
+			exp.Alternative = Fold(exp.Alternative).(*ast.BlockStatement)
+		}
+		if folded := pruneIf(exp); folded != nil {
+			return folded
+		}
+	case *ast.FunctionLiteral:
+		exp.Body = Fold(exp.Body).(*ast.BlockStatement)
+	case *ast.CallExpression:
+		exp.Function = foldExpression(exp.Function)
+		for i, a := range exp.Arguments {
+			exp.Arguments[i] = foldExpression(a)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range exp.Elements {
+			exp.Elements[i] = foldExpression(el)
+		}
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for i, k := range exp.Keys {
+			key := foldExpression(k)
+			pairs[key] = foldExpression(exp.Pairs[k])
+			exp.Keys[i] = key
+		}
+		exp.Pairs = pairs
+	case *ast.IndexExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Index = foldExpression(exp.Index)
+	case *ast.FieldExpression:
+		exp.Left = foldExpression(exp.Left)
+	case *ast.AssignExpression:
+		exp.Target = foldExpression(exp.Target)
+		exp.Value = foldExpression(exp.Value)
+	case *ast.MatchExpression:
+		exp.Subject = foldExpression(exp.Subject)
+		for _, arm := range exp.Arms {
+			if arm.Guard != nil {
+				arm.Guard = foldExpression(arm.Guard)
+			}
+			arm.Body = foldExpression(arm.Body)
+		}
+	}
+
+	return exp
+}
+
+// foldPrefix returns the literal exp evaluates to, or nil if its operand is not a literal
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	switch exp.Operator {
+	case "!":
+		truthy, ok := constantTruthiness(exp.Right)
+		if !ok {
+			return nil
+		}
+		return newBoolean(!truthy)
+	case "-":
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		return newInteger(-right.Value)
+	default:
+		return nil
+	}
+}
+
+// foldInfix returns the literal exp evaluates to, or nil if it can not be folded
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	switch left := exp.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		return foldIntegerInfix(exp.Operator, left.Value, right.Value)
+	case *ast.StringLiteral:
+		right, ok := exp.Right.(*ast.StringLiteral)
+		if !ok || exp.Operator != "+" {
+			return nil
+		}
+		return newString(left.Value + right.Value)
+	case *ast.Boolean:
+		right, ok := exp.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+		switch exp.Operator {
+		case "==":
+			return newBoolean(left.Value == right.Value)
+		case "!=":
+			return newBoolean(left.Value != right.Value)
+		}
+	}
+
+	return nil
+}
+
+func foldIntegerInfix(operator string, left, right int64) ast.Expression {
+	switch operator {
+	case "+":
+		return newInteger(left + right)
+	case "-":
+		return newInteger(left - right)
+	case "*":
+		return newInteger(left * right)
+	case "/":
+		// Dividing by zero is left for the engines to report at runtime
+		if right == 0 {
+			return nil
+		}
+		return newInteger(left / right)
+	case "<":
+		return newBoolean(left < right)
+	case ">":
+		return newBoolean(left > right)
+	case "==":
+		return newBoolean(left == right)
+	case "!=":
+		return newBoolean(left != right)
+	default:
+		return nil
+	}
+}
+
+// pruneIf returns the expression an if expression with a constant condition evaluates
+// to, when the branch that would run consists of a single expression
+func pruneIf(exp *ast.IfExpression) ast.Expression {
+	truthy, ok := constantTruthiness(exp.Condition)
+	if !ok {
+		return nil
+	}
+
+	branch := exp.Alternative
+	if truthy {
+		branch = exp.Consequence
+	}
+	if branch == nil || len(branch.Statements) != 1 {
+		return nil
+	}
+
+	es, ok := branch.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil
+	}
+
+	return es.Expression
+}
+
+// constantTruthiness reports whether exp is a literal, and if so whether it is truthy.
+// Only false is falsy, as there is no null literal.
+func constantTruthiness(exp ast.Expression) (bool, bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func newInteger(value int64) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: value}
+}
+
+func newBoolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
+
+func newString(value string) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value}, Value: value}
+}
@@ -0,0 +1,45 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
+	"github.com/JosueMolinaMorales/orionlang/internal/parser"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-(10 - 20)", "10"},
+		{"!(1 > 2)", "true"},
+		{"true == !false", "true"},
+		{`"a" + "b" + "c"`, "abc"},
+		{"x + 1 * 2", "(x + 2)"},
+		{"1 / 0", "(1 / 0)"},
+		{`"a" == "a"`, "(a == a)"},
+		{"let y = if (1 > 2) { x } else { 2 + 2 };", "let y = 4;"},
+		{"if (true) { let a = 1; a }", "let a = 1;a"},
+		{"if (false) { x }; y", "y"},
+		{"if (false) { x }", "iffalse x"},
+		{"if (x) { 1 + 1 }", "ifx 2"},
+		{"fn(a) { return 3 * 3; }", "fn(a)return 9;"},
+		{"f(1 + 1, [2 * 2], {1 + 2: 3 + 4})[0 + 1]", "(f(2, [4], {3:7})[1])"},
+		{"match 1 + 1 { 2 if 1 < 2 => 3 * 3, _ => 0 }", "match 2 { 2 if true => 9, _ => 0 }"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		folded := Fold(program)
+		if folded.String() != tt.expected {
+			t.Errorf("wrong result folding %q. want=%q, got=%q", tt.input, tt.expected, folded.String())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package optimizer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/code"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+)
+
+// instruction is a decoded instruction together with the position it was decoded from
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	wide     bool
+	pos      int
+	removed  bool
+}
+
+// Peephole rewrites wasteful instruction sequences emitted by the compiler and returns
+// the rewritten instructions. It removes jumps to the next instruction, `OpTrue;
+// OpJumpNotTruthy` and `OpNull; OpPop` pairs, turns `OpFalse; OpJumpNotTruthy` into an
+// `OpJump` and points jumps that land on another jump at its target. Every jump is
+// relocated to account for removed instructions. The instructions of every
+// CompiledFunction in constants are rewritten in place as well.
+func Peephole(ins code.Instructions, constants []object.Object) code.Instructions {
+	for _, c := range constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fn.Instructions = peephole(fn.Instructions)
+		}
+	}
+
+	return peephole(ins)
+}
+
+// peephole applies the rewrites to ins until none of them apply any more
+func peephole(ins code.Instructions) code.Instructions {
+	for {
+		instructions := decode(ins)
+
+		changed := threadJumps(instructions)
+		changed = removeSequences(instructions) || changed
+		if !changed {
+			return ins
+		}
+
+		ins = encode(instructions, len(ins))
+	}
+}
+
+// decode splits ins into its instructions
+func decode(ins code.Instructions) []*instruction {
+	instructions := []*instruction{}
+	for pos := 0; pos < len(ins); {
+		decoded, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			// Leave instructions we can not decode untouched
+			return nil
+		}
+
+		instructions = append(instructions, &instruction{
+			op:       decoded.Op,
+			operands: decoded.Operands,
+			wide:     decoded.Wide,
+			pos:      pos,
+		})
+		pos += decoded.Width
+	}
+
+	return instructions
+}
+
+// encode assembles instructions back into bytes, skipping removed instructions.
+// A jump to a removed instruction is relocated to the instruction that follows it.
+// end is the length of the instructions they were decoded from. Wide instructions stay
+// wide, so no instruction grows.
+func encode(instructions []*instruction, end int) code.Instructions {
+	newPositions := map[int]int{}
+	newPos := 0
+	for _, ins := range instructions {
+		newPositions[ins.pos] = newPos
+		if !ins.removed {
+			newPos += len(ins.make())
+		}
+	}
+	newPositions[end] = newPos
+
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		if ins.removed {
+			continue
+		}
+
+		if code.IsJump(ins.op) {
+			ins.operands[0] = newPositions[ins.operands[0]]
+		}
+		out = append(out, ins.make()...)
+	}
+
+	return out
+}
+
+// make encodes ins
+func (ins *instruction) make() []byte {
+	if ins.wide {
+		return code.MakeWide(ins.op, ins.operands...)
+	}
+
+	return code.Make(ins.op, ins.operands...)
+}
+
+// threadJumps points every jump that lands on an OpJump at the final target of the chain
+func threadJumps(instructions []*instruction) bool {
+	byPos := map[int]*instruction{}
+	for _, ins := range instructions {
+		byPos[ins.pos] = ins
+	}
+
+	changed := false
+	for _, ins := range instructions {
+		if !code.IsJump(ins.op) {
+			continue
+		}
+
+		target := ins.operands[0]
+		// Jumps only ever go forward, so following a chain always terminates
+		for next, ok := byPos[target]; ok && next.op == code.OpJump && next.operands[0] > target; next, ok = byPos[target] {
+			target = next.operands[0]
+		}
+
+		if target != ins.operands[0] {
+			ins.operands[0] = target
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// removeSequences removes or rewrites the wasteful sequences in instructions. A pair is
+// only rewritten when nothing jumps to its second instruction, since a jump to the first
+// one is relocated to whatever replaces the pair.
+func removeSequences(instructions []*instruction) bool {
+	targets := map[int]bool{}
+	for _, ins := range instructions {
+		if code.IsJump(ins.op) {
+			targets[ins.operands[0]] = true
+		}
+	}
+
+	changed := false
+	for i, ins := range instructions {
+		if ins.removed {
+			continue
+		}
+
+		if ins.op == code.OpJump && i+1 < len(instructions) && ins.operands[0] == instructions[i+1].pos {
+			ins.removed = true
+			changed = true
+			continue
+		}
+
+		if i+1 >= len(instructions) {
+			continue
+		}
+		next := instructions[i+1]
+		if targets[next.pos] {
+			continue
+		}
+
+		switch {
+		case ins.op == code.OpTrue && next.op == code.OpJumpNotTruthy:
+			ins.removed, next.removed = true, true
+			changed = true
+		case ins.op == code.OpFalse && next.op == code.OpJumpNotTruthy:
+			ins.removed = true
+			next.op = code.OpJump
+			changed = true
+		case ins.op == code.OpNull && next.op == code.OpPop:
+			ins.removed, next.removed = true, true
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Listing returns the listing of ins followed by the listing of every CompiledFunction
+// in constants, labelled with its constant index
+func Listing(ins code.Instructions, constants []object.Object) string {
+	var out bytes.Buffer
+
+	out.WriteString("main:\n")
+	out.WriteString(ins.String())
+	for i, c := range constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fmt.Fprintf(&out, "constant %d:\n", i)
+			out.WriteString(fn.Instructions.String())
+		}
+	}
+
+	return out.String()
+}
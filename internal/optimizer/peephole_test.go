@@ -0,0 +1,226 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/code"
+	"github.com/JosueMolinaMorales/orionlang/internal/compiler"
+	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/parser"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []code.Instructions
+		expected []code.Instructions
+	}{
+		{
+			"jump to next instruction",
+			[]code.Instructions{
+				code.Make(code.OpJump, 3),
+				code.Make(code.OpConstant, 0),
+			},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			"null followed by pop",
+			[]code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+			},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			"jump to jump",
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpGetGlobal, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0006
+				code.Make(code.OpConstant, 0),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpJump, 14),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 1),
+			},
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 14),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 14),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			"pair whose second instruction is a jump target",
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpGetGlobal, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 7),
+				// 0006
+				code.Make(code.OpNull),
+				// 0007
+				code.Make(code.OpPop),
+			},
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			"wide jump",
+			[]code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpPop),
+				// 0002
+				code.Make(code.OpGetGlobal, 0),
+				// 0005
+				code.MakeWide(code.OpJumpNotTruthy, 14),
+				// 0011
+				code.Make(code.OpConstant, 0),
+				// 0014
+				code.Make(code.OpConstant, 1),
+			},
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.MakeWide(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		optimized := Peephole(concat(tt.input), nil)
+
+		expected := concat(tt.expected)
+		if optimized.String() != expected.String() {
+			t.Errorf("%s: wrong instructions.\nwant=%q\ngot=%q", tt.name, expected.String(), optimized.String())
+		}
+	}
+}
+
+func TestPeepholeCompiledPrograms(t *testing.T) {
+	tests := []struct {
+		input             string
+		expected          []code.Instructions
+		expectedFunctions [][]code.Instructions
+	}{
+		{
+			input: "if (true) { 10 } else { 20 }; 3333;",
+			expected: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJump, 9),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpConstant, 2),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "if (false) { 10 } else { 20 }; 3333;",
+			expected: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 9),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpJump, 12),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpPop),
+				// 0013
+				code.Make(code.OpConstant, 2),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { if (true) { 1 } else { 2 } }",
+			expected: []code.Instructions{
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+			expectedFunctions: [][]code.Instructions{
+				{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpJump, 9),
+					// 0006
+					code.Make(code.OpConstant, 1),
+					// 0009
+					code.Make(code.OpReturnValue),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		optimized := Peephole(bytecode.Instructions, bytecode.Constants)
+
+		expected := concat(tt.expected)
+		if optimized.String() != expected.String() {
+			t.Errorf("wrong instructions for %q.\nwant=%q\ngot=%q", tt.input, expected.String(), optimized.String())
+		}
+
+		functions := []*object.CompiledFunction{}
+		for _, c := range bytecode.Constants {
+			if fn, ok := c.(*object.CompiledFunction); ok {
+				functions = append(functions, fn)
+			}
+		}
+
+		for i, fnInstructions := range tt.expectedFunctions {
+			expected := concat(fnInstructions)
+			if functions[i].Instructions.String() != expected.String() {
+				t.Errorf("wrong instructions for function %d of %q.\nwant=%q\ngot=%q",
+					i, tt.input, expected.String(), functions[i].Instructions.String())
+			}
+		}
+	}
+}
+
+func concat(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
@@ -55,6 +55,121 @@ func TestParsingHasLiteralWithExpressions(t *testing.T) {
 	}
 }
 
+func TestMatchExpressionParsing(t *testing.T) {
+	input := `match x {
+		0 => "zero",
+		-1 => "minus one",
+		[head, ...tail] => head,
+		{"type": "user", "id": id} if id > 0 => id,
+		[_, [a]] => a,
+		_ => false,
+	}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.MatchExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+
+	expectedArms := []string{
+		`0 => zero`,
+		`(-1) => minus one`,
+		`[head, ...tail] => head`,
+		`{type: user, id: id} if (id > 0) => id`,
+		`[_, [a]] => a`,
+		`_ => false`,
+	}
+
+	if len(exp.Arms) != len(expectedArms) {
+		t.Fatalf("wrong number of arms. want=%d, got=%d", len(expectedArms), len(exp.Arms))
+	}
+
+	for i, want := range expectedArms {
+		if exp.Arms[i].String() != want {
+			t.Errorf("arm %d wrong. want=%q, got=%q", i, want, exp.Arms[i].String())
+		}
+	}
+
+	if _, ok := exp.Arms[2].Pattern.(*ast.ArrayPattern); !ok {
+		t.Errorf("arm 2 pattern is not ast.ArrayPattern. got=%T", exp.Arms[2].Pattern)
+	}
+	if _, ok := exp.Arms[3].Pattern.(*ast.HashPattern); !ok {
+		t.Errorf("arm 3 pattern is not ast.HashPattern. got=%T", exp.Arms[3].Pattern)
+	}
+	if _, ok := exp.Arms[5].Pattern.(*ast.WildcardPattern); !ok {
+		t.Errorf("arm 5 pattern is not ast.WildcardPattern. got=%T", exp.Arms[5].Pattern)
+	}
+}
+
+func TestMatchExpressionParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match x { fn => 1 }`, "no pattern parse function for FUNCTION found"},
+		{`match x { [...] => 1 }`, "expected next token to be IDENT, got ] instead"},
+		{`match x { {a: 1} => 1 }`, "expected literal in pattern, got IDENT instead"},
+		{`match x { 1 2 }`, "expected next token to be =>, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if p.Errors()[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors()[0])
+		}
+	}
+}
+
+func TestParsingHashLiteralKeysInSourceOrder(t *testing.T) {
+	input := `{"b": 1, "a": 2, 3: 4, true: 5}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	expected := []string{"b", "a", "3", "true"}
+	if len(hash.Keys) != len(expected) {
+		t.Fatalf("hash.Keys has wrong length. got=%d", len(hash.Keys))
+	}
+
+	for i, key := range expected {
+		if hash.Keys[i].String() != key {
+			t.Errorf("hash.Keys[%d] wrong. want=%q, got=%q", i, key, hash.Keys[i].String())
+		}
+	}
+
+	if hash.String() != "{b:1, a:2, 3:4, true:5}" {
+		t.Errorf("hash.String() wrong. got=%q", hash.String())
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 
@@ -209,6 +324,110 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedPattern string
+	}{
+		{"let [a, b] = xs;", "[a, b]"},
+		{"let [a, b, ...rest] = xs;", "[a, b, ...rest]"},
+		{"let [_, [x, y]] = xs;", "[_, [x, y]]"},
+		{`let {"name": n, "age": a} = user;`, "{name: n, age: a}"},
+		{`let {"pos": [x, y]} = user;`, "{pos: [x, y]}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.Pattern == nil {
+			t.Fatalf("stmt.Pattern is nil")
+		}
+
+		if stmt.Pattern.String() != tt.expectedPattern {
+			t.Errorf("stmt.Pattern wrong. want=%q, got=%q", tt.expectedPattern, stmt.Pattern.String())
+		}
+	}
+}
+
+func TestDestructuringLetStatementErrors(t *testing.T) {
+	p := New(lexer.New("let [a, 1] = xs;"))
+	p.ParseProgram()
+
+	expected := "literal pattern 1 not allowed in let statement"
+	if len(p.Errors()) == 0 || p.Errors()[0] != expected {
+		t.Errorf("wrong parser errors. want=%q, got=%q", expected, p.Errors())
+	}
+}
+
+func TestStructStatements(t *testing.T) {
+	input := `struct Point { x, y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.StructStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Point" {
+		t.Errorf("stmt.Name.Value not 'Point'. got=%s", stmt.Name.Value)
+	}
+
+	if len(stmt.Fields) != 2 {
+		t.Fatalf("stmt.Fields wrong length. want=2, got=%d", len(stmt.Fields))
+	}
+
+	testIdentifier(t, stmt.Fields[0], "x")
+	testIdentifier(t, stmt.Fields[1], "y")
+}
+
+func TestStructParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, x }`, "duplicate field x in struct Point"},
+		{`struct { x }`, "expected next token to be IDENT, got { instead"},
+		{`x = 5`, "cannot assign to x"},
+		{`p.1`, "expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if p.Errors()[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors()[0])
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -517,6 +736,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a.b.c",
+			"((a.b).c)",
+		},
+		{
+			"-p.x + p.y * 2",
+			"((-(p.x)) + ((p.y) * 2))",
+		},
+		{
+			"a.b[0].c",
+			"(((a.b)[0]).c)",
+		},
+		{
+			"p.x = q.y = 1 + 2",
+			"((p.x) = ((q.y) = (1 + 2)))",
+		},
+		{
+			"h.m(1, 2 * 3).n",
+			"((h.m)(1, (2 * 3)).n)",
+		},
+		{
+			"self.x + 1",
+			"((self.x) + 1)",
+		},
 	}
 
 	for _, tt := range tests {
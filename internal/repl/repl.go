@@ -9,6 +9,7 @@ import (
 	"github.com/JosueMolinaMorales/orionlang/internal/evaluator"
 	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/optimizer"
 	"github.com/JosueMolinaMorales/orionlang/internal/parser"
 	"github.com/JosueMolinaMorales/orionlang/internal/vm"
 )
@@ -16,17 +17,29 @@ import (
 // PROMPT is the prompt of the REPL
 const PROMPT = ">> "
 
-// Start starts the REPL
-func Start(in io.Reader, out io.Writer, useInterpreter bool) {
+// Options configures how the REPL executes its input
+type Options struct {
+	// UseInterpreter evaluates input with the interpreter instead of the compiler
+	UseInterpreter bool
+	// Optimize runs the constant-folding pass over input before executing it, compiles
+	// it with superinstructions and runs the peephole pass over the compiled instructions
+	Optimize bool
+	// DumpInstructions prints the instruction listings before and after the peephole pass
+	DumpInstructions bool
+}
+
+// Start starts the REPL. Programs read input from in and write output to out, like the
+// REPL itself. A program reading input gets the lines that follow the one it was typed
+// on, and the REPL reads its next line after the ones the program read.
+func Start(in io.Reader, out io.Writer, opts Options) {
 	scanner := bufio.NewScanner(in)
+	streams := &object.IO{Stdin: &lineReader{scanner: scanner}, Stdout: out, Stderr: out}
 	env := object.NewEnvironment()
+	env.SetIO(streams)
 
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -45,7 +58,15 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 			continue
 		}
 
-		if useInterpreter {
+		for _, w := range optimizer.EliminateDeadCode(program) {
+			fmt.Fprintf(out, "Warning: %s\n", w)
+		}
+
+		if opts.Optimize {
+			optimizer.Fold(program)
+		}
+
+		if opts.UseInterpreter {
 			evaluated := evaluator.Eval(program, env)
 			if evaluated != nil {
 				io.WriteString(out, evaluated.Inspect())
@@ -55,6 +76,7 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 		}
 		// Compiler
 		comp := compiler.NewWithState(symbolTable, constants)
+		comp.SetSuperinstructions(opts.Optimize)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Compilation failed:\n %s\n", err)
@@ -62,9 +84,22 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 		}
 
 		code := comp.Bytecode()
+		if opts.Optimize {
+			if opts.DumpInstructions {
+				fmt.Fprintf(out, "Before peephole:\n%s", optimizer.Listing(code.Instructions, code.Constants))
+			}
+
+			// Only the functions compiled from this line have not been optimized yet
+			code.Instructions = optimizer.Peephole(code.Instructions, code.Constants[len(constants):])
+
+			if opts.DumpInstructions {
+				fmt.Fprintf(out, "After peephole:\n%s", optimizer.Listing(code.Instructions, code.Constants))
+			}
+		}
 		constants = code.Constants
 
 		machine := vm.NewWithGlobalsStore(code, globals)
+		machine.SetIO(streams)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
@@ -78,6 +113,33 @@ func Start(in io.Reader, out io.Writer, useInterpreter bool) {
 	}
 }
 
+// lineReader reads the lines of the scanner the REPL reads its input with. Programs can
+// not read in directly: the scanner buffers input ahead of the line it returns, so they
+// would miss the lines it buffered and take lines away from the REPL.
+type lineReader struct {
+	scanner *bufio.Scanner
+	// pending is the rest of the line being read
+	pending []byte
+}
+
+// Read reads from the current line, scanning the next one once it has been read
+func (r *lineReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		// The scanner reuses its buffer, so the line is copied
+		r.pending = append(append([]byte{}, r.scanner.Bytes()...), '\n')
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
 func PrintParserErrors(out io.Writer, errors []string) {
 	io.WriteString(out, "Woops! We ran into some errors!\n")
 	io.WriteString(out, " parser errors:\n")
@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader("let a = 1 + 2;\na * 2\n"), &out, Options{Optimize: true})
+
+	expected := ">> 3\n>> 6\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("first\nsecond\nthird\nfourth"))
+	reader := &lineReader{scanner: scanner}
+
+	if !scanner.Scan() || scanner.Text() != "first" {
+		t.Fatalf("wrong first line. got=%q", scanner.Text())
+	}
+
+	// A program reading input gets the line after the one the REPL read, a byte at a
+	// time if it asks for that little
+	line := make([]byte, 4)
+	n, err := io.ReadFull(reader, line)
+	if err != nil || string(line[:n]) != "seco" {
+		t.Fatalf("wrong input. got=%q, %v", line[:n], err)
+	}
+	rest, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil || rest != "nd\n" {
+		t.Fatalf("wrong rest of the line. got=%q, %v", rest, err)
+	}
+
+	// and the REPL goes on after the lines the program read
+	if !scanner.Scan() || scanner.Text() != "third" {
+		t.Fatalf("wrong line after the program. got=%q", scanner.Text())
+	}
+
+	remaining, err := io.ReadAll(reader)
+	if err != nil || string(remaining) != "fourth\n" {
+		t.Errorf("wrong remaining input. got=%q, %v", remaining, err)
+	}
+}
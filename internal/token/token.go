@@ -7,6 +7,10 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Line and Column locate the first character of the token in the source, starting at 1.
+	// They are 0 for tokens that were not read from source.
+	Line   int
+	Column int
 }
 
 const (
@@ -31,10 +35,14 @@ const (
 	LT = "<"
 	GT = ">"
 
+	ARROW    = "=>"
+	ELLIPSIS = "..."
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -51,6 +59,8 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	MATCH    = "MATCH"
+	STRUCT   = "STRUCT"
 )
 
 var keywords = map[string]TokenType{
@@ -61,6 +71,8 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"match":  MATCH,
+	"struct": STRUCT,
 }
 
 // LookupIdent checks the keywords table to see whether the given
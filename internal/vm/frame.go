@@ -14,6 +14,9 @@ type Frame struct {
 	// basePointer is the pointer that points to the bottom of the stack of the current
 	// call frame
 	basePointer int
+	// receiver is the value the function was called on when it was called as a method.
+	// It is nil for plain function calls
+	receiver object.Object
 }
 
 // NewFrame creates a new frame with the given compiled function and base pointer.
@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/code"
@@ -9,15 +11,41 @@ import (
 )
 
 const (
-	StackSize   = 2046
+	// StackSize is the default limit on the number of values on the stack
+	StackSize   = 1 << 20
 	GlobalsSize = 65536
-	MaxFrames   = 1024
+	// MaxFrames is the default limit on the number of frames on the call stack
+	MaxFrames = 1 << 16
+
+	// initialStackSize and initialFrames are the sizes the stacks start out with.
+	// They grow on demand up to the VM's limits.
+	initialStackSize = 256
+	initialFrames    = 64
+
+	// limitCheckInterval is the number of instructions executed between checks of the
+	// context passed to RunContext
+	limitCheckInterval = 1024
 )
 
+// Limits bounds the resources a VM may use while running. A zero field selects the default.
+type Limits struct {
+	// StackSize is the largest number of values the stack may hold
+	StackSize int
+	// MaxFrames is the largest number of frames the call stack may hold, including the
+	// frame of the main program, which bounds the call depth
+	MaxFrames int
+	// MaxInstructions is the largest number of instructions a run may execute. Zero means
+	// no limit.
+	MaxInstructions int
+	// MaxMemory is the largest number of bytes a run may allocate for arrays, hashes,
+	// strings and structs, counting every allocation. Zero means no limit.
+	MaxMemory int
+}
+
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
 // VM represents a virtual machine that executes bytecode instructions.
@@ -30,6 +58,40 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	limits Limits
+	// budget accounts for the memory allocated by the current run
+	budget *object.Budget
+
+	// running is whether the VM is executing instructions, under ctx. executed counts
+	// the instructions executed since it started, and checkAt is the count at which the
+	// limits are checked next.
+	running  bool
+	ctx      context.Context
+	executed int
+	checkAt  int
+
+	// builtinNames are the names of the builtins the program uses, and builtins the
+	// builtins they resolve to in registry when the program is run
+	builtinNames []string
+	registry     *object.Registry
+	builtins     []*object.Builtin
+
+	// io is where builtins read input and write output
+	io *object.IO
+	// builtinErr is the error of a call made by the builtin being called, which stops
+	// the program once the builtin returns
+	builtinErr error
+
+	// fieldSlots caches the slot of the field named by each FieldName constant, by the
+	// index of the constant
+	fieldSlots []fieldSlot
+}
+
+// fieldSlot is the slot a field was found in, in structs of type def
+type fieldSlot struct {
+	def  *object.StructType
+	slot int
 }
 
 // New creates a new instance of the VM with the given bytecode.
@@ -38,19 +100,28 @@ func New(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
 	mainFrame := NewFrame(mainFn, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, initialFrames)
 	frames[0] = mainFrame
 
 	return &VM{
 		constants: bytecode.Constants,
 
-		stack: make([]object.Object, StackSize),
+		stack: make([]object.Object, initialStackSize),
 		sp:    0,
 
 		globals: make([]object.Object, GlobalsSize),
 
 		frames:      frames,
 		framesIndex: 1,
+
+		limits: Limits{StackSize: StackSize, MaxFrames: MaxFrames},
+
+		builtinNames: bytecode.Builtins,
+		registry:     object.NewStandardRegistry(),
+
+		io: object.StandardIO(),
+
+		fieldSlots: make([]fieldSlot, len(bytecode.Constants)),
 	}
 }
 
@@ -63,6 +134,41 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetBuiltins sets the registry the builtins used by the program are looked up in. Run
+// fails if the program uses a builtin the registry does not have. It must be called
+// before Run.
+func (vm *VM) SetBuiltins(registry *object.Registry) {
+	vm.registry = registry
+}
+
+// SetIO sets the streams builtins read input from and write output to, in place of the
+// standard streams of the process
+func (vm *VM) SetIO(io *object.IO) {
+	vm.io = io
+}
+
+// SetLimits sets the limits the stacks may grow to. Zero fields select the defaults.
+// It must be called before Run.
+func (vm *VM) SetLimits(limits Limits) {
+	if limits.StackSize == 0 {
+		limits.StackSize = StackSize
+	}
+	if limits.MaxFrames == 0 {
+		limits.MaxFrames = MaxFrames
+	}
+
+	// The stacks only check their limits when they are full, so they can not start out
+	// larger than their limits
+	if len(vm.stack) > limits.StackSize {
+		vm.stack = vm.stack[:limits.StackSize]
+	}
+	if len(vm.frames) > limits.MaxFrames {
+		vm.frames = vm.frames[:limits.MaxFrames]
+	}
+
+	vm.limits = limits
+}
+
 // StackTop returns the top element of the stack.
 // If the stack is empty, it returns nil.
 func (vm *VM) StackTop() object.Object {
@@ -75,13 +181,119 @@ func (vm *VM) StackTop() object.Object {
 // Run executes the instructions stored in the VM.
 // It iterates over each instruction, fetches the current instruction,
 // and performs the corresponding operation based on the OpCode.
-// If an error occurs during execution, it is returned.
+// If an error occurs during execution, it is returned. Malformed bytecode that would
+// make the VM panic is reported as an error as well.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext executes the instructions stored in the VM like Run, but stops once ctx is
+// done or the run executes more instructions or allocates more memory than the VM's
+// limits allow. It then returns a *object.LimitError, which matches
+// object.ErrExecutionLimit with errors.Is.
+func (vm *VM) RunContext(ctx context.Context) (err error) {
+	defer vm.finish(&err)
+
+	err = vm.start(ctx)
+	if err != nil {
+		return err
+	}
+
+	return vm.run(0)
+}
+
+// Call calls fn, a function, builtin or struct type, with args and returns its result.
+// It can be called once Run has returned, to call the functions the program defined, or
+// by a builtin while the VM runs, to call a function the program passed to it. A call
+// made while the VM runs is held to the limits and context of the run.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	return vm.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext calls fn like Call. A call made while the VM is not running stops once ctx
+// is done or it exceeds the VM's limits, like RunContext.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object, err error) {
+	if !vm.running {
+		defer vm.finish(&err)
+
+		err = vm.start(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sp, framesIndex := vm.sp, vm.framesIndex
+	defer func() {
+		// A call that failed part of the way through leaves its frames and values behind
+		if err != nil {
+			vm.sp, vm.framesIndex = sp, framesIndex
+		}
+	}()
+
+	err = vm.growStack(sp + 1 + len(args))
+	if err != nil {
+		return nil, err
+	}
+	vm.stack[sp] = fn
+	copy(vm.stack[sp+1:], args)
+	vm.sp = sp + 1 + len(args)
+
+	err = vm.executeCall(len(args))
+	if err != nil {
+		return nil, err
+	}
+
+	// Builtins and struct types return straight away, functions once their frame is popped
+	err = vm.run(framesIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.pop(), nil
+}
+
+// start prepares the VM to execute instructions under ctx
+func (vm *VM) start(ctx context.Context) error {
+	vm.running = true
+	vm.ctx = ctx
+	// Instructions are counted by run, the budget only accounts for memory
+	vm.budget = object.NewBudget(ctx, 0, vm.limits.MaxMemory)
+	vm.executed = 0
+	vm.checkAt = 0
+
+	return vm.resolveBuiltins()
+}
+
+// finish ends the execution started by start. Malformed bytecode that made the VM panic
+// is reported in *err.
+func (vm *VM) finish(err *error) {
+	vm.running = false
+
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("internal error: %v", r)
+	}
+}
+
+// run executes instructions until the frame at index stopAt returns or, for the main
+// frame, runs out of instructions
+func (vm *VM) run(stopAt int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > stopAt && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		// The limits are only checked every so often, since checking a context is slow.
+		// Calls made by builtins run nested in this loop and count their instructions
+		// too, so the count may have passed checkAt.
+		if vm.executed >= vm.checkAt {
+			err := vm.checkLimits(vm.ctx, vm.executed)
+			if err != nil {
+				return err
+			}
+			vm.checkAt = vm.nextLimitCheck(vm.executed)
+		}
+		vm.executed++
+
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
@@ -103,6 +315,15 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpAddConst:
+			constIndex := code.ReadUInt16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			left := vm.pop()
+			err := vm.executeBinaryOperands(code.OpAdd, left, vm.constants[constIndex])
+			if err != nil {
+				return err
+			}
 		case code.OpEqual, code.OpGreaterThan, code.OpNotEqual:
 			err := vm.executeComparisonOperation(op)
 			if err != nil {
@@ -128,9 +349,15 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpJump:
+		case code.OpJump, code.OpCase:
 			pos := int(code.ReadUInt16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
+		case code.OpMatchTable:
+			tableIndex := int(code.ReadUInt16(ins[ip+1:]))
+			numCases := int(code.ReadUInt16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			vm.executeMatchTable(tableIndex, numCases)
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUInt16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -139,6 +366,18 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpIfNotGreater:
+			pos := int(code.ReadUInt16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			greater, err := vm.popGreaterThan()
+			if err != nil {
+				return err
+			}
+
+			if !greater {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -161,10 +400,7 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUInt16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-
-			err := vm.push(array)
+			err := vm.executeArray(numElements)
 			if err != nil {
 				return err
 			}
@@ -172,12 +408,7 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUInt16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			err = vm.push(hash)
+			err := vm.executeHash(numElements)
 			if err != nil {
 				return err
 			}
@@ -197,6 +428,14 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTailCall:
+			numArgs := code.ReadUInt8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
@@ -233,22 +472,292 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+			frame := vm.currentFrame()
+
+			err := vm.push(vm.stack[frame.basePointer+int(op-code.OpGetLocal0)])
+			if err != nil {
+				return err
+			}
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUInt8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
+			err := vm.push(vm.builtins[builtinIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpMatchLiteral:
+			literal := vm.pop()
+			value := vm.pop()
+
+			err := vm.push(nativeBoolToBooleanObject(literalsEqual(value, literal)))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchArray:
+			numElements := int(code.ReadUInt16(ins[ip+1:]))
+			hasRest := code.ReadUInt8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			err := vm.executeMatchArray(vm.pop(), numElements, hasRest)
+			if err != nil {
+				return err
+			}
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
 
-			err := vm.push(definition.Builtin)
+			err := vm.push(nativeBoolToBooleanObject(ok))
 			if err != nil {
 				return err
 			}
+		case code.OpHasKey:
+			key := vm.pop()
+			hash := vm.pop()
+
+			err := vm.executeHasKey(hash, key)
+			if err != nil {
+				return err
+			}
+		case code.OpArrayRest:
+			start := int(code.ReadUInt16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			err := vm.executeArrayRest(vm.pop(), start)
+			if err != nil {
+				return err
+			}
+		case code.OpGetField:
+			nameIndex := code.ReadUInt16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.executeGetField(int(nameIndex))
+			if err != nil {
+				return err
+			}
+		case code.OpSetField:
+			nameIndex := code.ReadUInt16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			target := vm.pop()
+
+			err := vm.executeSetField(target, int(nameIndex), value)
+			if err != nil {
+				return err
+			}
+		case code.OpCallMethod:
+			numArgs := code.ReadUInt8(ins[ip+1:])
+			nameIndex := code.ReadUInt16(ins[ip+2:])
+			vm.currentFrame().ip += 3
+
+			err := vm.executeMethodCall(int(numArgs), int(nameIndex))
+			if err != nil {
+				return err
+			}
+		case code.OpGetSelf:
+			receiver := vm.currentFrame().receiver
+			if receiver == nil {
+				receiver = Null
+			}
+
+			err := vm.push(receiver)
+			if err != nil {
+				return err
+			}
+		case code.OpWide:
+			instruction, err := code.ReadInstruction(ins[ip:])
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += instruction.Width - 1
+
+			err = vm.executeWide(instruction.Op, instruction.Operands)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveBuiltins looks up the builtins used by the program in the VM's registry
+func (vm *VM) resolveBuiltins() error {
+	vm.builtins = make([]*object.Builtin, len(vm.builtinNames))
+	for i, name := range vm.builtinNames {
+		builtin, ok := vm.registry.Lookup(name)
+		if !ok {
+			return fmt.Errorf("undefined builtin %s", name)
+		}
+		vm.builtins[i] = builtin
+	}
+
+	return nil
+}
+
+// checkLimits returns a LimitError if ctx is done or the next instruction would go over
+// the maximum number of instructions
+func (vm *VM) checkLimits(ctx context.Context, executed int) error {
+	if vm.limits.MaxInstructions > 0 && executed >= vm.limits.MaxInstructions {
+		return object.CountLimitError(vm.limits.MaxInstructions, "instructions")
+	}
+	if ctx.Err() != nil {
+		return object.ContextLimitError(ctx)
+	}
+
+	return nil
+}
+
+// nextLimitCheck returns the number of executed instructions at which the limits are
+// checked next, which is no later than when the maximum number of instructions is reached
+func (vm *VM) nextLimitCheck(executed int) int {
+	next := executed + limitCheckInterval
+	if max := vm.limits.MaxInstructions; max > 0 && max < next {
+		return max
+	}
+
+	return next
+}
+
+// executeWide executes an instruction that was prefixed by OpWide. Wide instructions are
+// rare, so they are kept out of the main dispatch loop.
+func (vm *VM) executeWide(op code.Opcode, operands []int) error {
+	switch op {
+	case code.OpConstant:
+		return vm.push(vm.constants[operands[0]])
+	case code.OpAddConst:
+		left := vm.pop()
+		return vm.executeBinaryOperands(code.OpAdd, left, vm.constants[operands[0]])
+	case code.OpJump, code.OpCase:
+		vm.currentFrame().ip = operands[0] - 1
+	case code.OpMatchTable:
+		vm.executeMatchTable(operands[0], operands[1])
+	case code.OpJumpNotTruthy:
+		if !isTruthy(vm.pop()) {
+			vm.currentFrame().ip = operands[0] - 1
+		}
+	case code.OpJumpIfNotGreater:
+		greater, err := vm.popGreaterThan()
+		if err != nil {
+			return err
+		}
+		if !greater {
+			vm.currentFrame().ip = operands[0] - 1
 		}
+	case code.OpArray:
+		return vm.executeArray(operands[0])
+	case code.OpHash:
+		return vm.executeHash(operands[0])
+	case code.OpCall:
+		return vm.executeCall(operands[0])
+	case code.OpTailCall:
+		return vm.executeTailCall(operands[0])
+	case code.OpSetLocal:
+		vm.stack[vm.currentFrame().basePointer+operands[0]] = vm.pop()
+	case code.OpGetLocal:
+		return vm.push(vm.stack[vm.currentFrame().basePointer+operands[0]])
+	case code.OpGetBuiltin:
+		return vm.push(vm.builtins[operands[0]])
+	case code.OpMatchArray:
+		return vm.executeMatchArray(vm.pop(), operands[0], operands[1] == 1)
+	case code.OpArrayRest:
+		return vm.executeArrayRest(vm.pop(), operands[0])
+	case code.OpGetField:
+		return vm.executeGetField(operands[0])
+	case code.OpSetField:
+		value := vm.pop()
+		return vm.executeSetField(vm.pop(), operands[0], value)
+	case code.OpCallMethod:
+		return vm.executeMethodCall(operands[0], operands[1])
+	default:
+		return fmt.Errorf("opcode %d can not be wide", op)
 	}
 
 	return nil
 }
 
+// executeMatchTable pops the subject of a match and jumps to the target of the entry the
+// MatchTable constant at tableIndex selects for it. The numCases OpCase entries of the
+// table follow the current instruction, and the last of them is taken when the table
+// has no case for the subject.
+func (vm *VM) executeMatchTable(tableIndex, numCases int) {
+	frame := vm.currentFrame()
+	table := vm.constants[tableIndex].(*object.MatchTable)
+
+	arm, ok := table.Case(vm.pop())
+	if !ok {
+		arm = numCases - 1
+	}
+
+	// The entries are all narrow or all wide, so the selected one can be read directly
+	ins := frame.Instructions()
+	entries := frame.ip + 1
+	var target int
+	if code.Opcode(ins[entries]) == code.OpWide {
+		target = int(code.ReadUInt32(ins[entries+arm*6+2:]))
+	} else {
+		target = int(code.ReadUInt16(ins[entries+arm*3+1:]))
+	}
+	frame.ip = target - 1
+}
+
+// executeArray replaces the top numElements values on the stack with an array holding them
+func (vm *VM) executeArray(numElements int) error {
+	err := vm.budget.Allocate(object.ArraySize(numElements))
+	if err != nil {
+		return err
+	}
+
+	array := vm.buildArray(vm.sp-numElements, vm.sp)
+	vm.sp = vm.sp - numElements
+
+	return vm.push(array)
+}
+
+// executeHash replaces the top numElements values on the stack, alternating keys and
+// values, with a hash holding them
+func (vm *VM) executeHash(numElements int) error {
+	err := vm.budget.Allocate(object.HashSize(numElements / 2))
+	if err != nil {
+		return err
+	}
+
+	hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - numElements
+
+	return vm.push(hash)
+}
+
+// executeGetField pops a struct or hash and pushes the value of the field named by the
+// constant at nameIndex
+func (vm *VM) executeGetField(nameIndex int) error {
+	value, err := vm.fieldValue(vm.pop(), nameIndex)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(value)
+}
+
+// popGreaterThan pops two integers and reports whether the lower one is greater than the
+// top one
+func (vm *VM) popGreaterThan() (bool, error) {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if !leftOk || !rightOk {
+		return false, fmt.Errorf("unknown operator: %d (%s %s)", code.OpGreaterThan, left.Type(), right.Type())
+	}
+
+	return leftInt.Value > rightInt.Value, nil
+}
+
 // LastPoppedStackElem returns the last element popped from the stack.
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
@@ -261,6 +770,8 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callFunction(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.StructType:
+		return vm.callStructType(callee, numArgs)
 	default:
 		return fmt.Errorf("calling non-function and non-built-in")
 	}
@@ -276,18 +787,72 @@ func (vm *VM) callFunction(fn *object.CompiledFunction, numArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(fn, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 
+	err = vm.growStack(frame.basePointer + fn.NumLocals)
+	if err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + fn.NumLocals
 
 	return nil
 }
 
+// executeTailCall calls the callee below the arguments on top of the stack, whose result
+// the current function returns straight away. A compiled function replaces the current
+// frame instead of getting a new one: the callee and its arguments are moved down to the
+// current frame's function slot and the frame is reset to run the callee, so a chain of
+// tail calls runs in constant stack. Any other callee is called as usual, and the
+// OpReturnValue following the call returns its result.
+func (vm *VM) executeTailCall(numArgs int) error {
+	calleeIndex := vm.sp - 1 - numArgs
+	fn, ok := vm.stack[calleeIndex].(*object.CompiledFunction)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+
+	if numArgs != fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+	}
+
+	basePointer := vm.currentFrame().basePointer
+	err := vm.growStack(basePointer + fn.NumLocals)
+	if err != nil {
+		return err
+	}
+
+	copy(vm.stack[basePointer-1:], vm.stack[calleeIndex:vm.sp])
+	vm.frames[vm.framesIndex-1] = NewFrame(fn, basePointer)
+
+	vm.sp = basePointer + fn.NumLocals
+
+	return nil
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	result := builtin.Fn(&object.BuiltinContext{Budget: vm.budget, IO: vm.io, Call: vm.callFromBuiltin}, args...)
 	vm.sp = vm.sp - numArgs - 1
 
+	if err := vm.builtinErr; err != nil {
+		vm.builtinErr = nil
+		return err
+	}
+
+	// A builtin that ran out of memory returns an error object like any other error,
+	// but running out of memory stops the program with the limit error
+	if err := vm.budget.Err(); err != nil {
+		return err
+	}
+
+	// An error returned by a builtin stops the program, as it stops the interpreter
+	if errObj, ok := result.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -297,15 +862,156 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// callFromBuiltin calls fn for a builtin. A call that fails stops the program once the
+// builtin returns, as it would have if the program had called fn itself.
+func (vm *VM) callFromBuiltin(fn object.Object, args ...object.Object) (object.Object, error) {
+	result, err := vm.Call(fn, args...)
+	if err != nil && vm.builtinErr == nil {
+		vm.builtinErr = err
+	}
+
+	return result, err
+}
+
+// callStructType constructs a struct from the arguments on the stack, one per field
+// in declaration order, and replaces the callee and its arguments with it.
+func (vm *VM) callStructType(def *object.StructType, numArgs int) error {
+	if numArgs != len(def.Fields) {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", len(def.Fields), numArgs)
+	}
+
+	err := vm.budget.Allocate(object.ArraySize(numArgs))
+	if err != nil {
+		return err
+	}
+
+	fields := make([]object.Object, numArgs)
+	copy(fields, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.push(&object.Struct{Def: def, Fields: fields})
+}
+
+// executeMethodCall calls the function stored in the named field of the receiver that
+// sits below the arguments on the stack. The receiver's stack slot is replaced with the
+// function, so the call proceeds like any other, and the receiver is bound to self.
+func (vm *VM) executeMethodCall(numArgs int, nameIndex int) error {
+	receiverIndex := vm.sp - 1 - numArgs
+	receiver := vm.stack[receiverIndex]
+
+	method, err := vm.fieldValue(receiver, nameIndex)
+	if err != nil {
+		return err
+	}
+	vm.stack[receiverIndex] = method
+
+	err = vm.executeCall(numArgs)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := method.(*object.CompiledFunction); ok {
+		vm.currentFrame().receiver = receiver
+	}
+
+	return nil
+}
+
+// fieldValue returns the value stored in the field of a struct named by the constant at
+// nameIndex, under the string key with that name in a hash, or the property or method
+// with that name of a host object
+func (vm *VM) fieldValue(target object.Object, nameIndex int) (object.Object, error) {
+	if s, ok := target.(*object.Struct); ok {
+		slot, err := vm.structSlot(s, nameIndex)
+		if err != nil {
+			return nil, err
+		}
+		return s.Fields[slot], nil
+	}
+
+	name := vm.constants[nameIndex].(*object.FieldName).Name
+	switch target := target.(type) {
+	case *object.Hash:
+		// h.key is sugar for h["key"]
+		value, ok := target.Get(&object.String{Value: name})
+		if !ok {
+			return Null, nil
+		}
+		return value, nil
+	case *object.HostObject:
+		value, ok := target.Field(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s on %s", name, target.Def.Name)
+		}
+		// A property that fails stops the program, like a builtin that returns an error
+		if errObj, ok := value.(*object.Error); ok {
+			return nil, errors.New(errObj.Message)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("field access not supported: %s", target.Type())
+	}
+}
+
+// executeSetField stores value in the field of a struct named by the constant at
+// nameIndex and pushes value back, since an assignment evaluates to the assigned value.
+func (vm *VM) executeSetField(target object.Object, nameIndex int, value object.Object) error {
+	s, ok := target.(*object.Struct)
+	if !ok {
+		return fmt.Errorf("field assignment not supported: %s", target.Type())
+	}
+
+	slot, err := vm.structSlot(s, nameIndex)
+	if err != nil {
+		return err
+	}
+
+	s.Fields[slot] = value
+	return vm.push(value)
+}
+
+// structSlot returns the slot of s that holds the field named by the FieldName constant
+// at nameIndex. Each constant belongs to one instruction, which caches the struct type it
+// last accessed and the slot the field has in it, so the name is only looked up when an
+// instruction sees a struct type for the first time.
+func (vm *VM) structSlot(s *object.Struct, nameIndex int) (int, error) {
+	cached := &vm.fieldSlots[nameIndex]
+	if cached.def == s.Def {
+		return cached.slot, nil
+	}
+
+	name := vm.constants[nameIndex].(*object.FieldName).Name
+	slot, ok := s.Def.FieldIndex(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown field %s on %s", name, s.Def.Name)
+	}
+
+	*cached = fieldSlot{def: s.Def, slot: slot}
+	return slot, nil
+}
+
 // currentFrame returns the current frame in the VM's call stack.
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-// pushFrame pushes a new frame onto the VM's call stack.
-func (vm *VM) pushFrame(f *Frame) {
+// pushFrame pushes a new frame onto the VM's call stack, growing it if it is full.
+// It returns a stack overflow error if the call stack is at its limit.
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		if vm.framesIndex >= vm.limits.MaxFrames {
+			return vm.stackOverflow()
+		}
+
+		frames := make([]*Frame, grownSize(len(vm.frames), vm.framesIndex+1, vm.limits.MaxFrames))
+		copy(frames, vm.frames)
+		vm.frames = frames
+	}
+
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+
+	return nil
 }
 
 // popFrame pops the top frame from the VM's call stack.
@@ -316,24 +1022,23 @@ func (vm *VM) popFrame() *Frame {
 
 // buildHash builds a hash object from a range of stack elements.
 // It takes the start and end indices of the range and returns the resulting hash object and an error, if any.
+// Pairs keep the order they appear on the stack, which is their order in the source.
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable has hash key: %s", key.Type())
+		hashKey, err := object.AsHashable(key)
+		if err != nil {
+			return nil, err
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 // buildArray builds an array object from the elements on the VM stack.
@@ -387,17 +1092,71 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	key, err := object.AsHashable(index)
+	if err != nil {
+		return err
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+// executeMatchArray pushes true if value is an array with exactly numElements elements,
+// or at least numElements elements when the pattern has a rest element.
+func (vm *VM) executeMatchArray(value object.Object, numElements int, hasRest bool) error {
+	array, ok := value.(*object.Array)
+	if !ok {
+		return vm.push(False)
+	}
+
+	length := len(array.Elements)
+	matched := length == numElements || (hasRest && length > numElements)
+
+	return vm.push(nativeBoolToBooleanObject(matched))
+}
+
+// executeHasKey pushes true if hash is a hash that contains key.
+// If key is not usable as a hash key, it returns an error.
+func (vm *VM) executeHasKey(hash, key object.Object) error {
+	hashObject, ok := hash.(*object.Hash)
+	if !ok {
+		return fmt.Errorf("key lookup not supported: %s", hash.Type())
+	}
+
+	hashKey, err := object.AsHashable(key)
+	if err != nil {
+		return err
+	}
+
+	_, ok = hashObject.Get(hashKey)
+	return vm.push(nativeBoolToBooleanObject(ok))
+}
+
+// executeArrayRest pushes a new array holding the elements of array from start onwards.
+func (vm *VM) executeArrayRest(array object.Object, start int) error {
+	arrayObject, ok := array.(*object.Array)
+	if !ok {
+		return fmt.Errorf("rest pattern not supported: %s", array.Type())
+	}
+
+	length := len(arrayObject.Elements)
+	if start > length {
+		start = length
+	}
+
+	err := vm.budget.Allocate(object.ArraySize(length - start))
+	if err != nil {
+		return err
+	}
+
+	elements := make([]object.Object, length-start)
+	copy(elements, arrayObject.Elements[start:])
+
+	return vm.push(&object.Array{Elements: elements})
 }
 
 // executeMinusOperator performs the execution of the minus operator in the virtual machine.
@@ -448,6 +1207,9 @@ func (vm *VM) executeComparisonOperation(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -478,6 +1240,22 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 	}
 }
 
+// executeStringComparison compares two strings by their value, since equal strings built
+// at runtime are different objects. Only equality operators are supported.
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
 // executeBinaryOperation executes a binary operation on the top two values on the VM's stack.
 // It takes an opcode as a parameter and returns an error if the operation is not supported for the given types.
 // The method first pops the top two values from the stack and determines their types.
@@ -491,6 +1269,12 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	return vm.executeBinaryOperands(op, left, right)
+}
+
+// executeBinaryOperands executes a binary operation on left and right, which have
+// already been popped from the stack, and pushes the result.
+func (vm *VM) executeBinaryOperands(op code.Opcode, left, right object.Object) error {
 	leftType := left.Type()
 	rightType := right.Type()
 
@@ -516,6 +1300,11 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
+	err := vm.budget.Allocate(object.StringSize(len(leftValue) + len(rightValue)))
+	if err != nil {
+		return err
+	}
+
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
@@ -547,9 +1336,12 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 // push pushes the given object onto the stack.
 // It returns an error if the stack is already full.
 func (vm *VM) push(o object.Object) error {
-	// check to see if the stackpointer went over the limit
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	// check to see if the stackpointer went over the end of the stack
+	if vm.sp >= len(vm.stack) {
+		err := vm.growStack(vm.sp + 1)
+		if err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -558,6 +1350,41 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// growStack grows the stack so it holds at least size values. It returns a stack
+// overflow error if that is more than the stack's limit.
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.limits.StackSize {
+		return vm.stackOverflow()
+	}
+
+	stack := make([]object.Object, grownSize(len(vm.stack), size, vm.limits.StackSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+
+	return nil
+}
+
+// grownSize doubles size until it is at least needed, without going over limit
+func grownSize(size, needed, limit int) int {
+	for size < needed {
+		size *= 2
+	}
+	if size > limit {
+		return limit
+	}
+
+	return size
+}
+
+// stackOverflow returns the error for a stack that would grow over its limit. The call
+// depth is the number of function calls in progress.
+func (vm *VM) stackOverflow() error {
+	return fmt.Errorf("stack overflow at call depth %d", vm.framesIndex-1)
+}
+
 // pop removes and returns the top element from the stack.
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
@@ -574,6 +1401,24 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return False
 }
 
+// literalsEqual reports whether value has the same type and value as the given
+// integer, string or boolean literal.
+func literalsEqual(value, literal object.Object) bool {
+	switch literal := literal.(type) {
+	case *object.Integer:
+		v, ok := value.(*object.Integer)
+		return ok && v.Value == literal.Value
+	case *object.String:
+		v, ok := value.(*object.String)
+		return ok && v.Value == literal.Value
+	case *object.Boolean:
+		v, ok := value.(*object.Boolean)
+		return ok && v.Value == literal.Value
+	default:
+		return false
+	}
+}
+
 // isTruthy checks if the given object is considered truthy.
 // It returns true if the object is a non-null boolean with a value of true,
 // and false otherwise.
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/compiler"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+)
+
+const fibInput = `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`
+
+const loopInput = `
+let loop = fn(i, n, acc) { if (n > i) { loop(i + 1, n, acc + i) } else { acc } };
+loop(0, 10000, 0)`
+
+const fieldsInput = `
+struct Vec { a, b, c, d, e, f, x, y };
+let step = fn(v, n) { if (n > 0) { v.y = v.y + v.x; step(v, n - 1) } else { v.y } };
+step(Vec(0, 0, 0, 0, 0, 0, 1, 0), 10000)`
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkBothEncodings(b, fibInput)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmarkBothEncodings(b, loopInput)
+}
+
+func BenchmarkFields(b *testing.B) {
+	benchmarkBothEncodings(b, fieldsInput)
+}
+
+// benchmarkBothEncodings runs input compiled without and with superinstructions
+func benchmarkBothEncodings(b *testing.B, input string) {
+	b.Run("plain", func(b *testing.B) {
+		benchmarkProgram(b, input, false)
+	})
+	b.Run("superinstructions", func(b *testing.B) {
+		benchmarkProgram(b, input, true)
+	})
+}
+
+func benchmarkProgram(b *testing.B, input string, superinstructions bool) {
+	comp := compiler.New()
+	comp.SetSuperinstructions(superinstructions)
+	err := comp.Compile(parse(input))
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+
+		if _, ok := vm.LastPoppedStackElem().(*object.Integer); !ok {
+			b.Fatalf("result is not Integer. got=%T", vm.LastPoppedStackElem())
+		}
+	}
+}
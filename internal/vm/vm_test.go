@@ -1,10 +1,18 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/JosueMolinaMorales/orionlang/internal/ast"
+	"github.com/JosueMolinaMorales/orionlang/internal/code"
 	"github.com/JosueMolinaMorales/orionlang/internal/compiler"
 	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
 	"github.com/JosueMolinaMorales/orionlang/internal/object"
@@ -30,6 +38,13 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 
 		vm := New(comp.Bytecode())
 		err = vm.Run()
+		// An error object returned by a builtin stops the program with its message
+		if expected, ok := tt.expected.(*object.Error); ok {
+			if err == nil || err.Error() != expected.Message {
+				t.Errorf("wrong VM error for %q. want=%q, got=%v", tt.input, expected.Message, err)
+			}
+			continue
+		}
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
@@ -84,11 +99,61 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `push` must be ARRAY, got INTEGER",
 			},
 		},
+		{
+			`let x = len(1); 5`,
+			&object.Error{
+				Message: "argument to `len` not supported, got INTEGER",
+			},
+		},
+		{
+			`[first(1), 2]`,
+			&object.Error{
+				Message: "argument to `first` must be ARRAY, got INTEGER",
+			},
+		},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestBuiltinRegistry(t *testing.T) {
+	scaled := func(factor int64) *object.Builtin {
+		return &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * factor}
+		}}
+	}
+
+	doubling := object.NewRegistry()
+	doubling.Register("scale", scaled(2))
+	tripling := object.NewStandardRegistry()
+	tripling.Register("scale", scaled(3))
+
+	comp := compiler.New()
+	comp.SetBuiltins(doubling)
+	err := comp.Compile(parse("scale(5)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	// The program refers to scale by name, so it runs against any registry that has it
+	for registry, expected := range map[*object.Registry]int{doubling: 10, tripling: 15} {
+		vm := New(bytecode)
+		vm.SetBuiltins(registry)
+		err := vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, expected, vm.LastPoppedStackElem())
+	}
+
+	vm := New(bytecode)
+	err = vm.Run()
+	if err == nil || err.Error() != "undefined builtin scale" {
+		t.Errorf("wrong VM error. want=%q, got=%v", "undefined builtin scale", err)
+	}
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -377,6 +442,342 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestHashKeyCollisions(t *testing.T) {
+	original := object.HashString
+	object.HashString = func(string) uint64 { return 1 }
+	defer func() { object.HashString = original }()
+
+	tests := []vmTestCase{
+		{`{"a": 1, "b": 2}["a"]`, 1},
+		{`{"a": 1, "b": 2}["b"]`, 2},
+		{`{"a": 1, "b": 2}["c"]`, Null},
+		{`let h = {"a": 1, "b": 2, "a": 3}; h["a"] + h["b"]`, 5},
+		{`match {"a": 1, "b": 2} { {"b": x} => x, _ => 0 }`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{`{[1, 2]: 3}[[1, 2]]`, 3},
+		{`{[1, 2]: 3}[[2, 1]]`, Null},
+		{`let grid = {[0, 0]: 1, [0, 1]: 2}; grid[[0, 1]]`, 2},
+		{`{[[1], "a"]: 5}[[[1], "a"]]`, 5},
+		{`{{"a": 1, "b": 2}: 7}[{"b": 2, "a": 1}]`, 7},
+		{`{{"a": 1}: 7}[{"a": 2}]`, Null},
+		{`let h = {[1]: 1, [1]: 2}; h[[1]]`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashKeyErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`{fn() { 1 }: 1}`, "unusable as hash key: COMPILED_FUNCTION_OBJ"},
+		{`{[1, fn() { 1 }]: 1}`, "unusable as hash key: COMPILED_FUNCTION_OBJ"},
+		{`struct P { x }; {P(1): 1}`, "unusable as hash key: STRUCT is mutable"},
+		{`struct P { x }; {"a": 1}[[P(1)]]`, "unusable as hash key: STRUCT is mutable"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn(a, b, c, d, e) { [a, b, c, d, e] }; f(1, 2, 3, 4, 5)`, []int{1, 2, 3, 4, 5}},
+		{`let x = 1; x + 2`, 3},
+		{`let s = "a"; s + "b"`, "ab"},
+		{`if (2 > 1) { 10 } else { 20 }`, 10},
+		{`if (1 > 1) { 10 } else { 20 }`, 20},
+		{`if (1 < 2) { 10 } else { 20 }`, 10},
+		{`match 5 { n if n < 3 => 1, n if n > 3 => 2, _ => 3 }`, 2},
+	}
+
+	runVmTests(t, tests)
+
+	errorTests := []vmTestCase{
+		{`if ("a" > "b") { 1 }`, "unknown operator: 10 (STRING STRING)"},
+		{`let x = true; x + 1`, "unsupported types for binary operation: BOOLEAN INTEGER"},
+	}
+
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	constants := joinNumbered(strconv.Itoa, 70001, "; ")
+	parameters := joinNumbered(identifier, 300, ", ")
+	arguments := joinNumbered(strconv.Itoa, 300, ", ")
+	lets := joinNumbered(func(i int) string { return fmt.Sprintf("let %s = %d", identifier(i), i) }, 300, "; ")
+	branch := joinNumbered(strconv.Itoa, 30000, "; ")
+
+	tests := []vmTestCase{
+		{fmt.Sprintf("let f = fn(%s) { %s }; f(%s)", parameters, identifier(299), arguments), 299},
+		{fmt.Sprintf("let f = fn() { %s; %s }; f()", lets, identifier(299)), 299},
+		{constants, 70000},
+		{constants + "; if (true) { 1 } else { 2 }", 1},
+		{constants + "; if (false) { 1 } else { 2 }", 2},
+		{constants + "; let f = fn(x) { if (x > 70000) { x } else { f(x + 70000) } }; f(1)", 70001},
+		{fmt.Sprintf("len([%s])", joinNumbered(strconv.Itoa, 70001, ", ")), 70001},
+		// Branches whose body is longer than 64KB of instructions
+		{fmt.Sprintf("if (true) { %s }; 5", branch), 5},
+		{fmt.Sprintf("if (false) { %s } else { 7 }", branch), 7},
+		{fmt.Sprintf("if (true) { %s } else { 7 }", branch), 29999},
+		{fmt.Sprintf("let f = fn(x) { if (x) { %s }; 5 }; f(true)", branch), 5},
+		{fmt.Sprintf("let f = fn(x) { if (x) { %s } else { 7 } }; f(false)", branch), 7},
+		{fmt.Sprintf("let f = fn(x) { match x { 1 => len([%s]), _ => 7 } }; [f(1), f(2)]", strings.ReplaceAll(branch, ";", ",")), []int{30000, 7}},
+		{fmt.Sprintf("let f = fn(x) { match x { 1 => len([%s]), 2 => 8, _ => 7 } }; [f(1), f(2), f(3)]", strings.ReplaceAll(branch, ";", ",")), []int{30000, 8, 7}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   Limits
+		expected string
+	}{
+		{`let f = fn(n) { 1 + f(n + 1) }; f(0)`, Limits{}, "stack overflow at call depth 65535"},
+		{`let f = fn(n) { 1 + f(n + 1) }; f(0)`, Limits{MaxFrames: 10}, "stack overflow at call depth 9"},
+		{`let f = fn(n) { 1 + f(n + 1) }; f(0)`, Limits{StackSize: 100}, "stack overflow at call depth 33"},
+		{fmt.Sprintf("[%s]", joinNumbered(strconv.Itoa, 300, ", ")), Limits{StackSize: 100}, "stack overflow at call depth 0"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+
+	forever := `let f = fn() { f() }; f()`
+	doubling := `let d = fn(s, n) { if (n == 0) { s } else { d(s + s, n - 1) } }; d("ab", 40)`
+	pushing := `let p = fn(a, n) { if (n == 0) { a } else { p(push(a, n), n - 1) } }; p([], 1000)`
+
+	tests := []struct {
+		ctx         context.Context
+		input       string
+		limits      Limits
+		expectedErr string
+		contextErr  error
+	}{
+		{context.Background(), "1; 2", Limits{MaxInstructions: 3}, "execution limit exceeded: more than 3 instructions", nil},
+		{context.Background(), forever, Limits{MaxInstructions: 5000}, "execution limit exceeded: more than 5000 instructions", nil},
+		{cancelled, "1; 2", Limits{}, "execution limit exceeded: context canceled", context.Canceled},
+		{timeout, forever, Limits{}, "execution limit exceeded: context deadline exceeded", context.DeadlineExceeded},
+		{context.Background(), doubling, Limits{MaxMemory: 1 << 20}, "execution limit exceeded: more than 1048576 bytes allocated", nil},
+		{context.Background(), pushing, Limits{MaxMemory: 10000}, "execution limit exceeded: more than 10000 bytes allocated", nil},
+		{context.Background(), "[1, 2, 3]", Limits{MaxMemory: 50}, "execution limit exceeded: more than 50 bytes allocated", nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.RunContext(tt.ctx)
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expectedErr {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expectedErr, err)
+		}
+		if !errors.Is(err, object.ErrExecutionLimit) {
+			t.Errorf("error %q does not match object.ErrExecutionLimit", err)
+		}
+		if tt.contextErr != nil && !errors.Is(err, tt.contextErr) {
+			t.Errorf("error %q does not match %q", err, tt.contextErr)
+		}
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(parse("1; 2"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxInstructions: 4})
+	err = vm.RunContext(context.Background())
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 2, vm.LastPoppedStackElem())
+
+	comp = compiler.New()
+	err = comp.Compile(parse("len(push([1, 2, 3], 4))"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm = New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxMemory: 200})
+	err = vm.RunContext(context.Background())
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 4, vm.LastPoppedStackElem())
+}
+
+func TestStackGrowth(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(50000)`, 50000},
+		{fmt.Sprintf("let a = [%s]; a[2999]", joinNumbered(strconv.Itoa, 3000, ", ")), 2999},
+	}
+
+	runVmTests(t, tests)
+}
+
+// TestRunDoesNotPanic runs every opcode with zeroed operands on an empty stack, along
+// with truncated instructions, none of which a compiler emits. Jumps go past the end,
+// since a jump to the start would loop forever.
+func TestRunDoesNotPanic(t *testing.T) {
+	programs := []code.Instructions{
+		{byte(code.OpConstant)},
+		{byte(code.OpWide)},
+		{byte(code.OpWide), byte(code.OpWide)},
+		{255},
+	}
+	for op := code.OpConstant; op <= code.OpWide; op++ {
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			t.Fatalf("opcode %d undefined", op)
+		}
+
+		operands := make([]int, len(def.OperandWidths))
+		if code.IsJump(op) {
+			operands[0] = 100
+		}
+		programs = append(programs, code.Make(op, operands...), code.MakeWide(op, operands...))
+	}
+
+	for _, ins := range programs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("vm panicked running %v: %v", ins, r)
+				}
+			}()
+
+			vm := New(&compiler.Bytecode{Instructions: ins})
+			vm.Run()
+		}()
+	}
+}
+
+// joinNumbered calls format with every number from 0 to n-1 and joins the results with sep
+func joinNumbered(format func(int) string, n int, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = format(i)
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// identifier returns a distinct identifier for i, spelled with letters only since
+// identifiers can not contain digits. The prefix keeps it from spelling a keyword.
+func identifier(i int) string {
+	name := []byte{}
+	for ; i >= 26; i = i/26 - 1 {
+		name = append([]byte{byte('a' + i%26)}, name...)
+	}
+
+	return "v" + string(append([]byte{byte('a' + i)}, name...))
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`{3: "x", 1: "y", 2: "z"}`, "{3: x, 1: y, 2: z}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`{"outer": {"z": 1, "y": 2}, true: false}`, "{outer: {z: 1, y: 2}, true: false}"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		actual := vm.LastPoppedStackElem().Inspect()
+		if actual != tt.expected {
+			t.Errorf("wrong Inspect output. want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -392,6 +793,13 @@ func TestStringExpressions(t *testing.T) {
 		{`"orion"`, "orion"},
 		{`"orion" + "lang"`, "orionlang"},
 		{`"orion" + "lang" + "rocks"`, "orionlangrocks"},
+		{`"orion" == "orion"`, true},
+		{`"orion" == "lang"`, false},
+		{`"orion" != "lang"`, true},
+		{`"orion" + "lang" == "orionlang"`, true},
+		{`let s = "orion"; let t = "or" + "ion"; s == t`, true},
+		{`let s = "orion"; let t = "or" + "ion"; s != t`, false},
+		{`"1" == 1`, false},
 	}
 	runVmTests(t, tests)
 }
@@ -406,6 +814,127 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`let [a, b] = [1, 2]; a + b`, 3},
+		{`let [a, b, ...rest] = [1, 2, 3, 4]; a + b + len(rest)`, 5},
+		{`let [a, b, c] = [1, 2]; c`, Null},
+		{`let [a, ...rest] = []; len(rest)`, 0},
+		{`let [_, [x, y]] = [1, [2, 3]]; x * y`, 6},
+		{`let {"name": n, "age": a} = {"name": "orion", "age": 3}; a`, 3},
+		{`let {"name": n, "missing": m} = {"name": "x"}; m`, Null},
+		{`let {"pos": [x, y]} = {"pos": [4, 5]}; x + y`, 9},
+		{`let f = fn(xs) { let [a, ...t] = xs; a + len(t) }; f([10, 20, 30])`, 12},
+		{`let [a, [b]] = [1]; a`, 1},
+		{`let [a, [b]] = [1]; b`, Null},
+		{`let [a, [b, ...c]] = [1]; len(c)`, 0},
+		{`let {"pos": {"x": x}} = {}; x`, Null},
+		{`let f = fn(xs) { let [[a], {"k": b}] = xs; b }; f([[1], {"k": 2}])`, 2},
+		{`let [[a, [b]], [c]] = [[1, [2]], [3]]; let [d] = [4]; a + b + c + d`, 10},
+		{`let f = fn(xs) { let [[a, [b]], [c]] = xs; let [d] = [4]; a + b + c + d }; f([[1, [2]], [3]])`, 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } };
+			count(100000, 0)`,
+			100000,
+		},
+		{
+			`let build = fn(n, acc) { if (n == 0) { acc } else { build(n - 1, push(acc, 1)) } };
+			let sum = fn(xs, acc) { match xs { [] => acc, [h, ...t] => sum(t, acc + h) } };
+			sum(build(5000, []), 0)`,
+			5000,
+		},
+		{
+			`let last = fn(xs) { if (len(xs) == 1) { first(xs) } else { return last(rest(xs)); } };
+			last([1, 2, 3])`,
+			3,
+		},
+		{
+			`let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5)`,
+			120,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []vmTestCase{
+		{`struct Point { x, y }; let p = Point(1, 2); p.x + p.y`, 3},
+		{`struct P { x }; let p = P(1); p.x = 5; p.x`, 5},
+		{`struct P { x }; let p = P(1); p.x = 5`, 5},
+		{`struct P { a, b }; let p = P(1, 2); let q = P(p, 3); q.a.b`, 2},
+		{`struct P { x }; let inc = fn(p) { p.x = p.x + 1 }; let p = P(1); inc(p); inc(p); p.x`, 3},
+		{`struct P { x, y }; let p = P(1, 2); let q = P(3, 4); p.x = q.y = 7; p.x + q.y`, 14},
+		{`struct Empty {}; len([Empty(), Empty()])`, 2},
+		// The same access sees structs whose field is in different slots
+		{`struct A { x, y }; struct B { y, x }; let get = fn(p) { p.y }; get(A(1, 2)) + get(B(3, 4)) + get(A(5, 6))`, 11},
+		{`struct A { x, y }; struct B { y, x }; let set = fn(p) { p.y = 10 }; let b = B(3, 4); set(A(1, 2)); set(b); b.y + b.x`, 14},
+		{`struct A { y }; let get = fn(p) { p.y }; get(A(1)) + get({"y": 2}) + get(A(3))`, 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDotAccessAndMethodCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{`let h = {"a": 1, "b": 2}; h.a + h.b`, 3},
+		{`{"a": 1}.missing`, Null},
+		{`let h = {"add": fn(a, b) { a + b }}; h.add(1, 2)`, 3},
+		{`let counter = {"n": 5, "get": fn() { self.n }}; counter.get()`, 5},
+		{`let h = {"n": 2, "scale": fn(x) { x * self.n }}; h.scale(21)`, 42},
+		{`let h = {"len": len}; h.len([1, 2, 3])`, 3},
+		{`struct Counter { n, inc }; let c = Counter(0, fn(by) { self.n = self.n + by }); c.inc(2); c.inc(3); c.n`, 5},
+		{`let o = {"inner": {"v": 7, "get": fn() { self.v }}}; o.inner.get()`, 7},
+		{`let a = {"x": 1, "f": fn() { self.x }}; let b = {"x": 2, "f": a.f}; b.f()`, 2},
+		{`let f = fn() { self }; f()`, Null},
+		{`let h = {"f": fn() { let g = fn() { self }; g() }}; h.f()`, Null},
+		{`self`, Null},
+		{`let self = 5; self`, 5},
+		{`let f = fn(self) { self * 2 }; f(3)`, 6},
+		{`let h = {"n": 1, "f": fn(self) { self }}; h.f(4)`, 4},
+		{`let h = {"n": 1, "f": fn() { let self = 9; self }}; h.f()`, 9},
+		{`let self = 5; let h = {"n": 1, "f": fn() { self.n }}; h.f()`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`struct P { x }; P(1, 2)`, "wrong number of arguments: want=1, got=2"},
+		{`struct P { x }; P(1).y`, "unknown field y on P"},
+		{`let a = [1]; a.x`, "field access not supported: ARRAY"},
+		{`let a = 1; a.x = 2`, "field assignment not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -422,6 +951,42 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match 2 { 1 => 10, 2 => 20, _ => 30 }`, 20},
+		{`match 5 { 1 => 10 }`, Null},
+		{`match "b" { "a" => 1, "b" => 2 }`, 2},
+		{`match true { false => 0, true => 1 }`, 1},
+		{`match -3 { -3 => 1, _ => 0 }`, 1},
+		{`match 1 { "1" => 1, _ => 0 }`, 0},
+		{`match [1, 2, 3] { [] => 0, [h, ...t] => h + len(t) }`, 3},
+		{`match [1, 2] { [a, b, c] => 3, [a, b] => a + b }`, 3},
+		{`match [] { [h, ...t] => 1, [] => 0 }`, 0},
+		{`match [1] { [h, ...t] => len(t) }`, 0},
+		{`match [1, [2, 3]] { [a, [b, c]] => a + b + c }`, 6},
+		{`match {"type": "user", "id": 7} { {"type": "admin"} => 0, {"type": "user", "id": id} => id }`, 7},
+		{`match {"id": 1} { {"name": n} => n, _ => 99 }`, 99},
+		{`match 1 { {"id": id} => id, [x] => x, _ => 3 }`, 3},
+		{`match 10 { x if x > 5 => 1, x => 2 }`, 1},
+		{`match 3 { x if x > 5 => 1, x => 2 }`, 2},
+		{`match 3 { x if match x { 3 => false, _ => true } => 1, _ => 2 }`, 2},
+		{`let f = fn(xs) { match xs { [h, ...t] => h + len(t), _ => 0 } }; f([5, 6, 7]) + f(1)`, 7},
+		{`let a = 1; match 5 { a if a > 10 => 2, _ => a }`, 1},
+		{`let a = 1; match [5] { [a] => a }; a`, 1},
+		{`let f = fn() { let a = 1; match 5 { a => a } + a }; f()`, 6},
+		{`match 5 { x => match 6 { x => x } + x }`, 11},
+		{`match "c" { "a" => 1, 2 => 2, "c" => 3, -4 => 4 }`, 3},
+		{`match -4 { "a" => 1, 2 => 2, "c" => 3, -4 => 4 }`, 4},
+		{`match "d" { "a" => 1, 2 => 2, "c" => 3, -4 => 4 }`, Null},
+		{`match 2 { 2 => 1, 2 => 2 }`, 1},
+		{`match 7 { 1 => 1, 2 => 2, x if x > 5 => x, _ => 0 }`, 7},
+		{`match 3 { 1 => 1, 2 => 2, x if x > 5 => x, 3 => 3, _ => 0 }`, 3},
+		{`let f = fn(x) { match x { 1 => "one", 2 => "two", _ => "many" } }; f(1) + f(2) + f(3)`, "onetwomany"},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -532,15 +1097,15 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.OrderedPairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs: %v", expectedKey)
+				t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
 			}
 			err := testIntegerObject(expectedValue, pair.Value)
 			if err != nil {
@@ -609,3 +1174,277 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func TestCall(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse(`
+	let handle = fn(event) { event["count"] * 2 };
+	let fail = fn(x) { x + true };
+	struct Point { x, y };
+	`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	handle, fail, point := vm.globals[0], vm.globals[1], vm.globals[2]
+
+	event := object.NewHash()
+	for i := 1; i <= 3; i++ {
+		event.Set(&object.String{Value: "count"}, &object.Integer{Value: int64(i)})
+
+		result, err := vm.Call(handle, event)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, i*2, result)
+	}
+
+	length, _ := object.NewStandardRegistry().Lookup("len")
+	tests := []struct {
+		fn          object.Object
+		args        []object.Object
+		expected    string
+		expectedErr string
+	}{
+		{length, []object.Object{&object.String{Value: "four"}}, "4", ""},
+		{point, []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}, "Point{x: 1, y: 2}", ""},
+		{handle, nil, "", "wrong number of arguments: want=1, got=0"},
+		{fail, []object.Object{&object.Integer{Value: 1}}, "", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{&object.Integer{Value: 1}, nil, "", "calling non-function and non-built-in"},
+	}
+
+	for _, tt := range tests {
+		result, err := vm.Call(tt.fn, tt.args...)
+		if tt.expectedErr != "" {
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("wrong VM error. want=%q, got=%v", tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+		}
+	}
+
+	// Failed calls leave the VM usable
+	if vm.sp != 0 || vm.framesIndex != 1 {
+		t.Errorf("calls left values or frames behind. sp=%d, framesIndex=%d", vm.sp, vm.framesIndex)
+	}
+	result, err := vm.Call(handle, event)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 6, result)
+}
+
+func TestCallFromBuiltin(t *testing.T) {
+	var machine *VM
+	registry := object.NewStandardRegistry()
+	registry.Register("apply", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		result, err := machine.Call(args[0], args[1:]...)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	}})
+
+	tests := []struct {
+		input       string
+		limits      Limits
+		expected    interface{}
+		expectedErr string
+	}{
+		{"let twice = fn(f, x) { apply(f, apply(f, x)) }; twice(fn(n) { n + 1 }, 5)", Limits{}, 7, ""},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + apply(f, n - 1) } }; f(100)", Limits{}, 100, ""},
+		{"apply(len, [1, 2, 3])", Limits{}, 3, ""},
+		{"apply(fn(x) { x + true }, 1)", Limits{}, nil, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{
+			"let f = fn(n) { if (n == 0) { 0 } else { 1 + apply(f, n - 1) } }; f(100)",
+			Limits{MaxInstructions: 500},
+			nil,
+			"execution limit exceeded: more than 500 instructions",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetBuiltins(registry)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine = New(comp.Bytecode())
+		machine.SetBuiltins(registry)
+		machine.SetLimits(tt.limits)
+		err = machine.Run()
+		if tt.expectedErr != "" {
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("wrong VM error for %q. want=%q, got=%v", tt.input, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestHostObjects(t *testing.T) {
+	request := &object.HostType{
+		Name: "Request",
+		Methods: map[string]object.HostMethod{
+			"header": func(ctx *object.BuiltinContext, value interface{}, args ...object.Object) object.Object {
+				return &object.String{Value: value.(map[string]string)[args[0].Inspect()]}
+			},
+		},
+		Properties: map[string]object.HostProperty{
+			"path": func(value interface{}) object.Object {
+				return &object.String{Value: value.(map[string]string)["path"]}
+			},
+			"broken": func(value interface{}) object.Object {
+				return &object.Error{Message: "boom"}
+			},
+		},
+		Key: func(value interface{}) interface{} { return value.(map[string]string)["path"] },
+	}
+	registry := object.NewStandardRegistry()
+	registry.Register("request", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		return &object.HostObject{Def: request, Value: map[string]string{"path": args[0].Inspect(), "x": "1"}}
+	}})
+
+	tests := []vmTestCase{
+		{`request("/a").header("x")`, "1"},
+		{`let r = request("/a"); r.path`, "/a"},
+		{`let h = request("/a").header; h("x") + h("y")`, "1"},
+		{`{request("/a"): 1}[request("/a")]`, 1},
+		{`{request("/a"): 1}[request("/b")]`, Null},
+		{`request("/a") == request("/a")`, false},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetBuiltins(registry)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetBuiltins(registry)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`request("/a").body`, "unknown field body on Request"},
+		{`let x = request("/a").broken; let y = 5; [x, y]`, "boom"},
+	}
+
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		comp.SetBuiltins(registry)
+		comp.Compile(parse(tt.input))
+		vm := New(comp.Bytecode())
+		vm.SetBuiltins(registry)
+		err := vm.Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinIO(t *testing.T) {
+	var stdout bytes.Buffer
+	comp := compiler.New()
+	err := comp.Compile(parse(`puts("hello", [1, 2]); puts(1 + 2)`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetIO(&object.IO{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: io.Discard})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if stdout.String() != "hello\n[1, 2]\n3\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "hello\n[1, 2]\n3\n", stdout.String())
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map([[1], [2, 3]], len)`, []int{1, 2}},
+		{`let n = 10; map([1, 2], fn(x) { x + n })`, []int{11, 12}},
+		{`map(map([1, 2], fn(x) { [x] }), first)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{`let seen = []; each([1, 2], fn(x) { seen = push(seen, x) })`, Null},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, Null},
+		{`any([1, 2, 3], fn(x) { x == 2 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`struct P { x }; map([1], P)[0].x`, 1},
+		{
+			`map(1, fn(x) { x })`,
+			&object.Error{Message: "first argument to `map` must be ARRAY, got INTEGER"},
+		},
+		{
+			`filter([1], 1)`,
+			&object.Error{Message: "last argument to `filter` must be a function, got INTEGER"},
+		},
+		{
+			`reduce([1], fn(acc, x) { acc })`,
+			&object.Error{Message: "wrong number of arguments. got=2, want=3"},
+		},
+	}
+
+	runVmTests(t, tests)
+
+	// A function that fails stops the program, as it would if the program called it
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1], fn(x) { x + true }); 1`, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`map([1], fn(x, y) { x }); 1`, "wrong number of arguments: want=2, got=1"},
+		{`map([[1]], fn(x) { map(x, fn(y) { -true }) }); 1`, "unsupported type for negation: BOOLEAN"},
+	}
+
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
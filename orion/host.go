@@ -0,0 +1,500 @@
+package orion
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/token"
+)
+
+var (
+	valueType   = reflect.TypeOf(Value{})
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	ioType      = reflect.TypeOf(IO{})
+)
+
+// Register makes the Go function fn a builtin of the default sandbox, like
+// Sandbox.Register
+func Register(name string, fn interface{}) error {
+	return defaultSandbox.Register(name, fn)
+}
+
+// Register makes the Go function fn callable as a builtin named name by the programs of
+// the sandbox, with both the VM and the interpreter. Programs compiled before Register is
+// called do not see the builtin. Register is not safe to call while programs of the
+// sandbox are compiled or run.
+//
+// The parameters and results of fn may be of the following types, which are converted
+// to and from the values of a program:
+//
+//   - bool, string and the integer types
+//   - slices of a supported type, converted to and from arrays
+//   - maps from a supported type to a supported type, converted to and from hashes
+//   - Value and interface{}, which accept any value; an interface{} parameter receives
+//     the value Value.Interface returns for it
+//
+// fn may return nothing, a value, an error, or a value followed by an error. A non-nil
+// error is raised in the program, as are calls with the wrong number of arguments or
+// arguments that do not convert to the parameter types. A variadic fn accepts any
+// number of trailing arguments. The values fn returns count towards the MaxMemory limit
+// of the execution.
+//
+// fn may start with a context.Context parameter, an IO parameter, or both in that
+// order. They are not passed by the program: the context is the one the execution runs
+// under, such as the one passed to Run, and the IO holds the streams of the execution.
+// A long-running fn should return once the context is done.
+//
+//	orion.Register("repeat", func(s string, n int) (string, error) {
+//		if n < 0 {
+//			return "", errors.New("negative count")
+//		}
+//		return strings.Repeat(s, n), nil
+//	})
+func (s *Sandbox) Register(name string, fn interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid builtin name %q", name)
+	}
+
+	builtin, err := hostFunction(name, fn)
+	if err != nil {
+		return err
+	}
+
+	return s.builtins.Register(name, builtin)
+}
+
+// isIdentifier reports whether name lexes as a single identifier
+func isIdentifier(name string) bool {
+	l := lexer.New(name)
+	tok := l.NextToken()
+
+	return tok.Type == token.IDENT && tok.Literal == name && l.NextToken().Type == token.EOF
+}
+
+// hostFunc is a Go function called by programs. Its arguments and results are converted
+// between objects and Go values.
+type hostFunc struct {
+	name string
+	fn   reflect.Value
+	// receivers is the number of leading parameters that are passed by the host rather
+	// than the program, such as the value a method is called on
+	receivers int
+	// takesContext and takesIO are whether the receivers are followed by a
+	// context.Context and an IO parameter, which are passed by the host as well
+	takesContext bool
+	takesIO      bool
+	// first is the index of the first parameter passed by the program
+	first      int
+	returnsErr bool
+	results    int
+}
+
+// newHostFunc checks that the parameters and results of fn can be converted. kind and
+// name describe fn in errors.
+func newHostFunc(kind, name string, fn interface{}, receivers int) (*hostFunc, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("%s %q is not a function: %T", kind, name, fn)
+	}
+
+	t := f.Type()
+	if t.NumIn() < receivers || (t.IsVariadic() && t.NumIn() == receivers) {
+		return nil, fmt.Errorf("%s %q must take the wrapped value as its first parameter", kind, name)
+	}
+
+	h := &hostFunc{name: name, fn: f, receivers: receivers, first: receivers, results: t.NumOut()}
+	if h.first < t.NumIn() && t.In(h.first) == contextType {
+		h.takesContext = true
+		h.first++
+	}
+	if h.first < t.NumIn() && t.In(h.first) == ioType {
+		h.takesIO = true
+		h.first++
+	}
+
+	for i := h.first; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if !supported(in) {
+			return nil, fmt.Errorf("%s %q: unsupported parameter type %s", kind, name, t.In(i))
+		}
+	}
+
+	h.returnsErr = t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	if h.returnsErr {
+		h.results--
+	}
+	if h.results > 1 {
+		return nil, fmt.Errorf("%s %q: too many results: %s", kind, name, t)
+	}
+	if h.results == 1 && !supported(t.Out(0)) {
+		return nil, fmt.Errorf("%s %q: unsupported result type %s", kind, name, t.Out(0))
+	}
+
+	return h, nil
+}
+
+// hostFunction wraps fn in a builtin that converts its arguments and results
+func hostFunction(name string, fn interface{}) (*object.Builtin, error) {
+	h, err := newHostFunc("builtin", name, fn, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &object.Builtin{
+		Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+			return h.call(ctx, nil, args)
+		},
+	}, nil
+}
+
+// call calls the function with receivers and the converted args on behalf of the
+// builtin called with ctx, and converts its result. Errors, and panics of the function,
+// are returned as error objects, the way builtins report them. ctx is nil when the
+// function is a property, which takes no context or IO.
+func (h *hostFunc) call(ctx *object.BuiltinContext, receivers []reflect.Value, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &object.Error{Message: fmt.Sprintf("`%s` panicked: %v", h.name, r)}
+		}
+	}()
+
+	in, err := h.arguments(args)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	var budget *object.Budget
+	if ctx != nil {
+		budget = ctx.Budget
+	}
+	if h.takesContext {
+		receivers = append(receivers, reflect.ValueOf(budget.Context()))
+		// A function that returns because the execution's context is done stops the
+		// execution with the same error as a program that runs until then
+		defer func() {
+			if err := budget.Context().Err(); err != nil {
+				result = &object.Error{Message: budget.Stop(object.ContextLimitError(budget.Context())).Error()}
+			}
+		}()
+	}
+	if h.takesIO {
+		streams := object.StandardIO()
+		if ctx != nil && ctx.IO != nil {
+			streams = ctx.IO
+		}
+		receivers = append(receivers, reflect.ValueOf(IO{Stdin: streams.Stdin, Stdout: streams.Stdout, Stderr: streams.Stderr}))
+	}
+
+	out := h.fn.Call(append(receivers, in...))
+	if h.returnsErr && !out[len(out)-1].IsNil() {
+		return &object.Error{Message: out[len(out)-1].Interface().(error).Error()}
+	}
+	if h.results == 0 {
+		return nil
+	}
+
+	result, err = toObject(out[0], budget)
+	if limitErr := budget.Err(); limitErr != nil {
+		return &object.Error{Message: limitErr.Error()}
+	}
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("result of `%s` %s", h.name, err)}
+	}
+
+	return result
+}
+
+// arguments converts the arguments of a call to the parameter types of the function
+func (h *hostFunc) arguments(args []object.Object) ([]reflect.Value, error) {
+	t := h.fn.Type()
+	fixed := t.NumIn() - h.first
+	if t.IsVariadic() {
+		fixed--
+		if len(args) < fixed {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want>=%d", len(args), fixed)
+		}
+	} else if len(args) != fixed {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), fixed)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if i < fixed {
+			paramType = t.In(h.first + i)
+		} else {
+			paramType = t.In(t.NumIn() - 1).Elem()
+		}
+
+		v, err := fromObject(arg, paramType)
+		if err != nil {
+			return nil, fmt.Errorf("%sargument %d to `%s` must be %s, got %s",
+				err.path, i+1, h.name, err.want, err.got)
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
+
+// supported reports whether values of type t can be converted to and from objects
+func supported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return supported(t.Elem())
+	case reflect.Map:
+		return supported(t.Key()) && supported(t.Elem())
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	case reflect.Struct:
+		return t == valueType
+	default:
+		return false
+	}
+}
+
+// conversionError describes an object that does not convert to a Go type. path locates
+// the object within an argument, such as "element 2 of ".
+type conversionError struct {
+	path string
+	want string
+	got  string
+}
+
+// typeName describes the values that convert to t
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return object.BOOLEAN_OBJ
+	case reflect.String:
+		return object.STRING_OBJ
+	case reflect.Slice:
+		return object.ARRAY_OBJ
+	case reflect.Map:
+		return object.HASH_OBJ
+	default:
+		return object.INTEGER_OBJ
+	}
+}
+
+// fromObject converts obj to a Go value of type t
+func fromObject(obj object.Object, t reflect.Type) (reflect.Value, *conversionError) {
+	if t == valueType {
+		return reflect.ValueOf(newValue(obj)), nil
+	}
+	if t.Kind() == reflect.Interface {
+		v := reflect.New(t).Elem()
+		if goValue := newValue(obj).Interface(); goValue != nil {
+			v.Set(reflect.ValueOf(goValue))
+		}
+		return v, nil
+	}
+
+	mismatch := func() (reflect.Value, *conversionError) {
+		got := object.NULL_OBJ
+		if obj != nil {
+			got = string(obj.Type())
+		}
+		return reflect.Value{}, &conversionError{want: typeName(t), got: got}
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(i.Value) {
+			return reflect.Value{}, &conversionError{want: "an INTEGER that fits in " + t.String(), got: i.Inspect()}
+		}
+		v.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return reflect.Value{}, &conversionError{want: "an INTEGER that fits in " + t.String(), got: i.Inspect()}
+		}
+		v.SetUint(uint64(i.Value))
+	case reflect.Slice:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+		for i, e := range arr.Elements {
+			element, err := fromObject(e, t.Elem())
+			if err != nil {
+				err.path = fmt.Sprintf("%selement %d of ", err.path, i)
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(element)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMapWithSize(t, hash.Len()))
+		for _, pair := range hash.OrderedPairs() {
+			key, err := fromObject(pair.Key, t.Key())
+			if err != nil {
+				err.path = fmt.Sprintf("%skey %s of ", err.path, pair.Key.Inspect())
+				return reflect.Value{}, err
+			}
+			value, err := fromObject(pair.Value, t.Elem())
+			if err != nil {
+				err.path = fmt.Sprintf("%svalue at %s of ", err.path, pair.Key.Inspect())
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(key, value)
+		}
+	}
+
+	return v, nil
+}
+
+// toObject converts the Go value v to an object, accounting for the objects it creates
+// in budget. nil is converted to null, which builtins return as a nil object.
+func toObject(v reflect.Value, budget *object.Budget) (object.Object, error) {
+	if v.Type() == valueType {
+		return v.Interface().(Value).obj, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toObject(v.Elem(), budget)
+	case reflect.Bool:
+		return object.NativeBool(v.Bool()), nil
+	case reflect.String:
+		if err := budget.Allocate(object.StringSize(v.Len())); err != nil {
+			return nil, err
+		}
+		return &object.String{Value: v.String()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if err := budget.Allocate(object.ObjectSize); err != nil {
+			return nil, err
+		}
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d does not fit in an INTEGER", v.Uint())
+		}
+		if err := budget.Allocate(object.ObjectSize); err != nil {
+			return nil, err
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if err := budget.Allocate(object.ArraySize(v.Len())); err != nil {
+			return nil, err
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := toObject(v.Index(i), budget)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = nullIfNil(element)
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if err := budget.Allocate(object.HashSize(v.Len())); err != nil {
+			return nil, err
+		}
+		keys := v.MapKeys()
+		// Go maps are unordered, so sort the keys to give the hash a stable order
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+
+		hash := object.NewHash()
+		for _, k := range keys {
+			key, err := toObject(k, budget)
+			if err != nil {
+				return nil, err
+			}
+			hashable, err := object.AsHashable(nullIfNil(key))
+			if err != nil {
+				return nil, err
+			}
+			value, err := toObject(v.MapIndex(k), budget)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, nullIfNil(value))
+		}
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// nullIfNil returns obj, or null if obj is nil. Builtins return null as nil, but arrays
+// and hashes must hold the null object.
+func nullIfNil(obj object.Object) object.Object {
+	if obj == nil {
+		return object.NULL
+	}
+
+	return obj
+}
+
+// lessKey orders the keys of a Go map
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a, b = a.Elem(), b.Elem()
+	}
+	if !a.IsValid() || !b.IsValid() {
+		return !a.IsValid() && b.IsValid()
+	}
+	if a.Kind() != b.Kind() {
+		return a.Kind() < b.Kind()
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	default:
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+}
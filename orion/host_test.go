@@ -0,0 +1,414 @@
+package orion_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JosueMolinaMorales/orionlang/orion"
+)
+
+func TestMain(m *testing.M) {
+	hostFunctions := map[string]interface{}{
+		"repeat": func(s string, n int) (string, error) {
+			if n < 0 {
+				return "", errors.New("negative count")
+			}
+			return strings.Repeat(s, n), nil
+		},
+		"sum": func(xs ...int64) int64 {
+			total := int64(0)
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"keys": func(m map[string]int) []string {
+			keys := []string{}
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"counts": func(words []string) map[string]int {
+			counts := map[string]int{}
+			for _, w := range words {
+				counts[w]++
+			}
+			return counts
+		},
+		"small":    func(n int8) int8 { return n },
+		"kind":     func(v orion.Value) string { return v.Kind().String() },
+		"identity": func(v interface{}) interface{} { return v },
+		"isEmpty":  func(s string) bool { return s == "" },
+		"nothing":  func() {},
+		"fail":     func() error { return errors.New("host failure") },
+	}
+
+	for name, fn := range hostFunctions {
+		if err := orion.Register(name, fn); err != nil {
+			panic(err)
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestHostFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`repeat("ab", 3)`, "ababab"},
+		{"sum()", int64(0)},
+		{"sum(1, 2, 3)", int64(6)},
+		{`keys({"b": 1, "a": 2})`, []interface{}{"a", "b"}},
+		{`counts(["a", "b", "a"])`, map[interface{}]interface{}{"a": int64(2), "b": int64(1)}},
+		{`counts(["b", "a"])`, map[interface{}]interface{}{"a": int64(1), "b": int64(1)}},
+		{"small(-128)", int64(-128)},
+		{"kind([1])", "array"},
+		{"kind(nothing())", "null"},
+		{`identity([1, "a", true])`, []interface{}{int64(1), "a", true}},
+		{`if (isEmpty("")) { 1 } else { 2 }`, int64(1)},
+		{`isEmpty("") == true`, true},
+		{"nothing()", nil},
+		{"let s = sum; s(4, 5)", int64(9)},
+	}
+
+	for _, e := range engines {
+		for _, tt := range tests {
+			result, err := e.run(context.Background(), tt.input, orion.Limits{})
+			if err != nil {
+				t.Fatalf("%s: error running %q: %s", e.name, tt.input, err)
+			}
+
+			if !reflect.DeepEqual(result.Interface(), tt.expected) {
+				t.Errorf("%s: wrong result for %q. want=%#v, got=%#v", e.name, tt.input, tt.expected, result.Interface())
+			}
+		}
+	}
+}
+
+func TestHostFunctionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`repeat("a")`, "wrong number of arguments. got=1, want=2"},
+		{`repeat("a", "b")`, "argument 2 to `repeat` must be INTEGER, got STRING"},
+		{`repeat("a", -1)`, "negative count"},
+		{`sum(1, "2")`, "argument 2 to `sum` must be INTEGER, got STRING"},
+		{`keys([1])`, "argument 1 to `keys` must be HASH, got ARRAY"},
+		{`keys({"a": "b"})`, "value at a of argument 1 to `keys` must be INTEGER, got STRING"},
+		{`counts(["a", 1])`, "element 1 of argument 1 to `counts` must be STRING, got INTEGER"},
+		{"small(128)", "argument 1 to `small` must be an INTEGER that fits in int8, got 128"},
+		{"isEmpty(nothing())", "argument 1 to `isEmpty` must be STRING, got NULL"},
+		{"fail()", "host failure"},
+		{"let x = fail(); 5", "host failure"},
+		{"[fail(), 1]", "host failure"},
+		{"let f = fn() { fail(); 1 }; f() + 1", "host failure"},
+	}
+
+	for _, e := range engines {
+		for _, tt := range tests {
+			_, err := e.run(context.Background(), tt.input, orion.Limits{})
+			if err == nil {
+				t.Fatalf("%s: expected error for %q", e.name, tt.input)
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: wrong error for %q. want=%q, got=%q", e.name, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       interface{}
+		expected string
+	}{
+		{"len", func() {}, `builtin "len" is already defined`},
+		{"repeat", func() {}, `builtin "repeat" is already defined`},
+		{"two words", func() {}, `invalid builtin name "two words"`},
+		{"let", func() {}, `invalid builtin name "let"`},
+		{"notFn", 1, `builtin "notFn" is not a function: int`},
+		{"floats", func(f float64) {}, `builtin "floats": unsupported parameter type float64`},
+		{"pair", func() (int, int) { return 0, 0 }, `builtin "pair": too many results: func() (int, int)`},
+		{"channel", func() chan int { return nil }, `builtin "channel": unsupported result type chan int`},
+	}
+
+	for _, tt := range tests {
+		err := orion.Register(tt.name, tt.fn)
+		if err == nil {
+			t.Fatalf("expected error registering %q", tt.name)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error registering %q. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestSandboxes(t *testing.T) {
+	english := orion.NewSandbox()
+	english.Register("greet", func(name string) string { return "hello " + name })
+	spanish := orion.NewEmptySandbox()
+	spanish.Register("greet", func(name string) string { return "hola " + name })
+
+	tests := []struct {
+		sandbox  *orion.Sandbox
+		input    string
+		expected string
+	}{
+		{english, `greet("orion")`, "hello orion"},
+		{spanish, `greet("orion")`, "hola orion"},
+		{english, `greet("a" + first(["b"]))`, "hello ab"},
+	}
+
+	for _, tt := range tests {
+		for _, run := range []func(context.Context, string, orion.Limits) (orion.Value, error){tt.sandbox.Run, tt.sandbox.Eval} {
+			result, err := run(context.Background(), tt.input, orion.Limits{})
+			if err != nil {
+				t.Fatalf("error running %q: %s", tt.input, err)
+			}
+
+			if s, _ := result.Str(); s != tt.expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, s)
+			}
+		}
+	}
+
+	// Builtins are only visible in the sandbox they were registered in
+	missing := []struct {
+		engine
+		input string
+	}{
+		{engine{"empty sandbox vm", spanish.Run}, "first([1])"},
+		{engine{"empty sandbox evaluator", spanish.Eval}, "first([1])"},
+		{engine{"default sandbox vm", orion.Run}, `greet("orion")`},
+		{engine{"default sandbox evaluator", orion.Eval}, `greet("orion")`},
+	}
+
+	for _, tt := range missing {
+		_, err := tt.run(context.Background(), tt.input, orion.Limits{})
+		if err == nil {
+			t.Errorf("%s: expected error running %q", tt.name, tt.input)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	sandbox := orion.NewSandbox()
+	sandbox.Register("explode", func() int { panic("boom") })
+
+	for _, e := range []engine{{"vm", sandbox.Run}, {"evaluator", sandbox.Eval}} {
+		for _, input := range []string{"explode(); 1", "map([1], fn(x) { explode() })"} {
+			_, err := e.run(context.Background(), input, orion.Limits{})
+			var runtimeErr *orion.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%s: expected a *orion.RuntimeError for %q. got=%v", e.name, input, err)
+			}
+			if runtimeErr.Message != "`explode` panicked: boom" {
+				t.Errorf("%s: wrong message for %q. got=%q", e.name, input, runtimeErr.Message)
+			}
+		}
+	}
+}
+
+func TestHostFunctionContext(t *testing.T) {
+	var out bytes.Buffer
+	sandbox := orion.NewSandbox()
+	sandbox.SetIO(orion.IO{Stdout: &out})
+	sandbox.Register("wait", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	sandbox.Register("greet", func(streams orion.IO, name string) {
+		fmt.Fprintf(streams.Stdout, "hello %s\n", name)
+	})
+	sandbox.Register("greetAll", func(ctx context.Context, streams orion.IO, names ...string) int {
+		for _, name := range names {
+			fmt.Fprintf(streams.Stdout, "hello %s\n", name)
+		}
+		return len(names)
+	})
+	sandbox.Register("numbers", func(n int) []int { return make([]int, n) })
+
+	for _, e := range []engine{{"vm", sandbox.Run}, {"evaluator", sandbox.Eval}} {
+		out.Reset()
+		result, err := e.run(context.Background(), `greet("orion"); greetAll("a", "b")`, orion.Limits{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", e.name, err)
+		}
+		if n, _ := result.Int(); n != 2 {
+			t.Errorf("%s: wrong result. want=2, got=%v", e.name, result.Interface())
+		}
+		if out.String() != "hello orion\nhello a\nhello b\n" {
+			t.Errorf("%s: wrong output. got=%q", e.name, out.String())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err = e.run(ctx, "wait(); 1", orion.Limits{})
+		cancel()
+		if !errors.Is(err, orion.ErrExecutionLimit) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected the deadline to stop the execution. got=%v", e.name, err)
+		}
+
+		// The results of host functions count towards the memory limit
+		_, err = e.run(context.Background(), "numbers(1000); 1", orion.Limits{MaxMemory: 10000})
+		if !errors.Is(err, orion.ErrExecutionLimit) {
+			t.Errorf("%s: expected the memory limit to stop the execution. got=%v", e.name, err)
+		}
+		_, err = e.run(context.Background(), "numbers(10); 1", orion.Limits{MaxMemory: 10000})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+		}
+	}
+}
+
+type request struct {
+	path    string
+	headers map[string]string
+}
+
+func TestHostTypes(t *testing.T) {
+	requestType, err := orion.NewHostType(orion.HostTypeConfig{
+		Name: "Request",
+		Methods: map[string]interface{}{
+			"header": func(r *request, name string) string { return r.headers[name] },
+		},
+		Properties: map[string]interface{}{
+			"path": func(r *request) string { return r.path },
+			"body": func(r *request) (string, error) { return "", errors.New("body already read") },
+		},
+		Inspect: func(value interface{}) string { return "<Request " + value.(*request).path + ">" },
+	})
+	if err != nil {
+		t.Fatalf("error creating host type: %s", err)
+	}
+
+	req := &request{path: "/users", headers: map[string]string{"x": "1"}}
+	sandbox := orion.NewSandbox()
+	sandbox.Register("current", func() orion.Value { return requestType.Wrap(req) })
+	sandbox.Register("unwrap", func(v interface{}) bool { return v == req })
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`current().header("x")`, "1"},
+		{`current().path + "/" + current().header("missing")`, "/users/"},
+		{"unwrap(current())", true},
+		{"len([current(), current()])", int64(2)},
+		{"current()", req},
+	}
+
+	for _, run := range []func(context.Context, string, orion.Limits) (orion.Value, error){sandbox.Run, sandbox.Eval} {
+		for _, tt := range tests {
+			result, err := run(context.Background(), tt.input, orion.Limits{})
+			if err != nil {
+				t.Fatalf("error running %q: %s", tt.input, err)
+			}
+
+			if result.Interface() != tt.expected {
+				t.Errorf("wrong result for %q. want=%#v, got=%#v", tt.input, tt.expected, result.Interface())
+			}
+		}
+
+		_, err := run(context.Background(), `current().header(1)`, orion.Limits{})
+		if err == nil || err.Error() != "argument 1 to `header` must be STRING, got INTEGER" {
+			t.Errorf("wrong error. got=%v", err)
+		}
+
+		_, err = run(context.Background(), `let b = current().body; let n = 5; [b, n]`, orion.Limits{})
+		if err == nil || err.Error() != "body already read" {
+			t.Errorf("wrong error for a failing property. got=%v", err)
+		}
+	}
+
+	program, err := sandbox.Compile(`req.header("x") + req.path`, "req")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	program.SetGlobal("req", requestType.Wrap(req))
+	result, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if s, _ := result.Str(); s != "1/users" {
+		t.Errorf("wrong result. want=%q, got=%q", "1/users", s)
+	}
+
+	program, _ = sandbox.Compile(`req.header("x")`, "req")
+	program.SetGlobal("req", requestType.Wrap("not a request"))
+	_, err = program.Run(context.Background())
+	if err == nil || err.Error() != "`header` can not be called on a Request wrapping string" {
+		t.Errorf("wrong error for wrong wrapped value. got=%v", err)
+	}
+
+	// A method that panics fails like one that returns an error
+	program.SetGlobal("req", requestType.Wrap((*request)(nil)))
+	_, err = program.Run(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "`header` panicked: ") {
+		t.Errorf("wrong error for a panicking method. got=%v", err)
+	}
+
+	// Keys that can not be compared are rejected rather than panicking
+	tagged, err := orion.NewHostType(orion.HostTypeConfig{
+		Name: "Tagged",
+		Key:  func(value interface{}) interface{} { return value.([]string) },
+	})
+	if err != nil {
+		t.Fatalf("error creating host type: %s", err)
+	}
+	sandbox.Register("tagged", func() orion.Value { return tagged.Wrap([]string{"a"}) })
+	for _, run := range []func(context.Context, string, orion.Limits) (orion.Value, error){sandbox.Run, sandbox.Eval} {
+		_, err := run(context.Background(), `{tagged(): 1}[tagged()]`, orion.Limits{})
+		expected := "unusable as hash key: Tagged has a key of type []string, which is not comparable"
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong error for a key that is not comparable. want=%q, got=%v", expected, err)
+		}
+	}
+}
+
+func TestHostTypeErrors(t *testing.T) {
+	tests := []struct {
+		config   orion.HostTypeConfig
+		expected string
+	}{
+		{orion.HostTypeConfig{Name: "not valid"}, `invalid host type name "not valid"`},
+		{
+			orion.HostTypeConfig{Name: "T", Methods: map[string]interface{}{"m": func() {}}},
+			`method "m" must take the wrapped value as its first parameter`,
+		},
+		{
+			orion.HostTypeConfig{Name: "T", Methods: map[string]interface{}{"m": func(v interface{}, f float64) {}}},
+			`method "m": unsupported parameter type float64`,
+		},
+		{
+			orion.HostTypeConfig{Name: "T", Properties: map[string]interface{}{"p": func(v, w interface{}) int { return 0 }}},
+			`property "p" must take only the wrapped value and return one result`,
+		},
+		{
+			orion.HostTypeConfig{Name: "T", Properties: map[string]interface{}{"p": 1}},
+			`property "p" is not a function: int`,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := orion.NewHostType(tt.config)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package orion
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+)
+
+// HostTypeConfig describes the Go values of a HostType to programs
+type HostTypeConfig struct {
+	// Name is the name of the type, used in error messages and, unless Inspect is set,
+	// to show its values
+	Name string
+	// Methods are called by programs as value.name(args). Each is a Go function whose
+	// first parameter receives the wrapped value, and whose other parameters and
+	// results are converted like those of the functions given to Register.
+	Methods map[string]interface{}
+	// Properties are read by programs as value.name. Each is a Go function that takes
+	// the wrapped value and returns the property, optionally followed by an error.
+	Properties map[string]interface{}
+	// Inspect returns the text of a wrapped value
+	Inspect func(value interface{}) string
+	// Key makes the values usable as hash keys when it is set. Two values are the same
+	// key when Key returns equal values for them, so it must return a comparable value
+	// such as a string or an ID. Using a value whose key is not comparable, such as a
+	// slice, as a hash key raises an error in the program.
+	Key func(value interface{}) interface{}
+}
+
+// HostType is a type of opaque values that wrap Go values, such as database rows or
+// requests. Programs can call the methods and read the properties of its values, but
+// can not see or change the wrapped Go value itself.
+type HostType struct {
+	def *object.HostType
+}
+
+// NewHostType creates a HostType, checking that its methods and properties can be
+// called from programs
+func NewHostType(config HostTypeConfig) (*HostType, error) {
+	if !isIdentifier(config.Name) {
+		return nil, fmt.Errorf("invalid host type name %q", config.Name)
+	}
+
+	def := &object.HostType{
+		Name:       config.Name,
+		Methods:    make(map[string]object.HostMethod, len(config.Methods)),
+		Properties: make(map[string]object.HostProperty, len(config.Properties)),
+		Inspect:    config.Inspect,
+		Key:        config.Key,
+	}
+
+	for name, fn := range config.Methods {
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid method name %q", name)
+		}
+		h, err := newHostFunc("method", name, fn, 1)
+		if err != nil {
+			return nil, err
+		}
+		def.Methods[name] = func(ctx *object.BuiltinContext, value interface{}, args ...object.Object) object.Object {
+			receiver, err := h.receiver(config.Name, value)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return h.call(ctx, []reflect.Value{receiver}, args)
+		}
+	}
+
+	for name, fn := range config.Properties {
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid property name %q", name)
+		}
+		if _, ok := config.Methods[name]; ok {
+			return nil, fmt.Errorf("%q is both a method and a property", name)
+		}
+		h, err := newHostFunc("property", name, fn, 1)
+		if err != nil {
+			return nil, err
+		}
+		if h.fn.Type().NumIn() != 1 || h.results != 1 {
+			return nil, fmt.Errorf("property %q must take only the wrapped value and return one result", name)
+		}
+		def.Properties[name] = func(value interface{}) object.Object {
+			receiver, err := h.receiver(config.Name, value)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return h.call(nil, []reflect.Value{receiver}, nil)
+		}
+	}
+
+	return &HostType{def: def}, nil
+}
+
+// Wrap returns value as a value of type t, which can be passed to programs with
+// SetGlobal or returned by registered functions
+func (t *HostType) Wrap(value interface{}) Value {
+	return Value{obj: &object.HostObject{Def: t.def, Value: value}}
+}
+
+// receiver converts the wrapped value of a host object of the type named typeName to
+// the first parameter of the function
+func (h *hostFunc) receiver(typeName string, value interface{}) (reflect.Value, error) {
+	paramType := h.fn.Type().In(0)
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		if !canBeNil(paramType) {
+			return reflect.Value{}, fmt.Errorf("`%s` can not be called on a nil %s", h.name, typeName)
+		}
+		return reflect.Zero(paramType), nil
+	}
+	if !v.Type().AssignableTo(paramType) {
+		return reflect.Value{}, fmt.Errorf("`%s` can not be called on a %s wrapping %s", h.name, typeName, v.Type())
+	}
+
+	return v, nil
+}
+
+// canBeNil reports whether nil is a value of type t
+func canBeNil(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,406 @@
+// Package orion embeds OrionLang in Go programs. It compiles and runs OrionLang source
+// with the bytecode VM, evaluates it with the tree-walking interpreter, and converts the
+// results to Go values.
+//
+//	program, err := orion.Compile(`let add = fn(a, b) { a + b }; add(1, 2)`)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := program.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	sum, err := result.Int() // 3
+//
+// # Compatibility
+//
+// This package follows semantic versioning. Within a major version, the exported
+// identifiers of this package are not removed or changed in a way that breaks code
+// using them, and a program that compiles and runs keeps producing the same result.
+// New identifiers, and new fields in structs such as Limits, may be added.
+//
+// Not covered by the guarantee are the text of error messages, the output of
+// Value.Inspect for functions and other values that have no Go counterpart, and the
+// bytecode a Program is compiled to. Match errors with errors.Is and errors.As rather
+// than by their text. Everything under internal/ may change at any time.
+package orion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/ast"
+	"github.com/JosueMolinaMorales/orionlang/internal/compiler"
+	"github.com/JosueMolinaMorales/orionlang/internal/evaluator"
+	"github.com/JosueMolinaMorales/orionlang/internal/lexer"
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+	"github.com/JosueMolinaMorales/orionlang/internal/optimizer"
+	"github.com/JosueMolinaMorales/orionlang/internal/parser"
+	"github.com/JosueMolinaMorales/orionlang/internal/vm"
+)
+
+// ErrExecutionLimit is matched, using errors.Is, by the error returned when a run
+// exceeds its Limits or its context is done
+var ErrExecutionLimit = object.ErrExecutionLimit
+
+// SyntaxError is returned for source that can not be parsed. It lists every error the
+// parser found.
+type SyntaxError struct {
+	Errors []string
+}
+
+func (e *SyntaxError) Error() string {
+	return "syntax error: " + strings.Join(e.Errors, "; ")
+}
+
+// RuntimeError is returned for an error raised while running a program, such as calling
+// a builtin with the wrong arguments or dividing by zero
+type RuntimeError struct {
+	Message string
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+// Limits bounds the resources a single run may use. Zero fields mean no limit.
+type Limits struct {
+	// MaxSteps is the largest number of instructions the VM may execute, or of nodes
+	// the interpreter may evaluate
+	MaxSteps int
+	// MaxMemory is the largest number of bytes a run may allocate for arrays, hashes,
+	// strings and structs, counting every allocation
+	MaxMemory int
+}
+
+// IO holds the streams builtins such as puts read input from and write output to. A nil
+// Stdin reads nothing, and writes to a nil Stdout or Stderr are discarded.
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (s IO) object() *object.IO {
+	streams := &object.IO{Stdin: s.Stdin, Stdout: s.Stdout, Stderr: s.Stderr}
+	if streams.Stdin == nil {
+		streams.Stdin = strings.NewReader("")
+	}
+	if streams.Stdout == nil {
+		streams.Stdout = io.Discard
+	}
+	if streams.Stderr == nil {
+		streams.Stderr = io.Discard
+	}
+
+	return streams
+}
+
+// Sandbox is a set of builtins that programs compiled or evaluated in it can call.
+// Sandboxes do not share builtins, so each one can grant programs different
+// capabilities. The package-level functions use a default sandbox with the standard
+// builtins.
+type Sandbox struct {
+	builtins *object.Registry
+	io       *object.IO
+}
+
+// NewSandbox creates a sandbox with the standard builtins: len, puts, first, last, rest,
+// push, map, filter, reduce, each, find, any and all
+func NewSandbox() *Sandbox {
+	return &Sandbox{builtins: object.NewStandardRegistry(), io: object.StandardIO()}
+}
+
+// NewEmptySandbox creates a sandbox without any builtins
+func NewEmptySandbox() *Sandbox {
+	return &Sandbox{builtins: object.NewRegistry(), io: object.StandardIO()}
+}
+
+// SetIO sets the streams of the programs evaluated in the sandbox, and of the programs
+// compiled in it afterwards. Sandboxes use the standard streams of the process until it
+// is called.
+func (s *Sandbox) SetIO(streams IO) {
+	s.io = streams.object()
+}
+
+var defaultSandbox = NewSandbox()
+
+// Program is OrionLang source compiled to bytecode, together with its global bindings.
+// A Program may be run any number of times, but not by several goroutines at once. Its
+// globals persist between runs: a run sees the values set with SetGlobal and the values
+// the previous run left, and GetGlobal reads the values the last run left.
+type Program struct {
+	bytecode *compiler.Bytecode
+	builtins *object.Registry
+	limits   Limits
+	io       *object.IO
+
+	// symbols maps the names of the globals to their slots in globals
+	symbols *compiler.SymbolTable
+	globals []object.Object
+
+	// hasResult is whether the last statement is an expression statement, whose value
+	// is the result of the program
+	hasResult bool
+}
+
+// Compile parses and compiles source into a Program in the default sandbox, like
+// Sandbox.Compile
+func Compile(source string, globals ...string) (*Program, error) {
+	return defaultSandbox.Compile(source, globals...)
+}
+
+// Compile parses and compiles source into a Program that can call the builtins of the
+// sandbox, optimizing it the way the orionlang command does. It returns a *SyntaxError
+// if source can not be parsed.
+//
+// globals names the globals the host sets with SetGlobal before running the program,
+// which source may use without defining them. They are null until they are set.
+func (s *Sandbox) Compile(source string, globals ...string) (*Program, error) {
+	program, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	optimizer.Fold(program)
+
+	symbols := compiler.NewSymbolTable()
+	for _, name := range globals {
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid global name %q", name)
+		}
+		symbols.Define(name)
+	}
+
+	comp := compiler.NewWithState(symbols, []object.Object{})
+	comp.SetBuiltins(s.builtins)
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := comp.Bytecode()
+	bytecode.Instructions = optimizer.Peephole(bytecode.Instructions, bytecode.Constants)
+
+	return &Program{
+		bytecode:  bytecode,
+		builtins:  s.builtins,
+		io:        s.io,
+		symbols:   symbols,
+		globals:   make([]object.Object, vm.GlobalsSize),
+		hasResult: endsInExpression(program),
+	}, nil
+}
+
+// SetLimits sets the limits every later run of the program is held to
+func (p *Program) SetLimits(limits Limits) {
+	p.limits = limits
+}
+
+// SetIO sets the streams every later run of the program uses, in place of those of the
+// sandbox it was compiled in
+func (p *Program) SetIO(streams IO) {
+	p.io = streams.object()
+}
+
+// Run runs the program with the VM and returns the value of its last expression
+// statement. It stops once ctx is done, returning an error that matches
+// ErrExecutionLimit.
+func (p *Program) Run(ctx context.Context) (Value, error) {
+	machine := p.machine()
+	err := machine.RunContext(ctx)
+	if err != nil {
+		return Value{}, runError(err)
+	}
+
+	if !p.hasResult {
+		return Value{}, nil
+	}
+
+	return result(machine.LastPoppedStackElem())
+}
+
+// Call calls a function of the program with the VM and returns its result. fn is either
+// the name of a global holding the function, such as one defined by a previous run, or a
+// Value holding a function the program returned. The arguments are converted the way
+// SetGlobal converts values. The call is held to the limits and uses the streams of the
+// program, and stops once ctx is done, returning an error that matches ErrExecutionLimit.
+func (p *Program) Call(ctx context.Context, fn interface{}, args ...interface{}) (Value, error) {
+	var callee object.Object
+	switch fn := fn.(type) {
+	case string:
+		index, err := p.globalIndex(fn)
+		if err != nil {
+			return Value{}, err
+		}
+		callee = nullIfNil(p.globals[index])
+	case Value:
+		callee = nullIfNil(fn.obj)
+	default:
+		return Value{}, fmt.Errorf("fn must be the name of a global or a Value, got %T", fn)
+	}
+
+	objects := make([]object.Object, len(args))
+	for i := range args {
+		obj, err := toObject(reflect.ValueOf(&args[i]).Elem(), nil)
+		if err != nil {
+			return Value{}, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objects[i] = nullIfNil(obj)
+	}
+
+	returned, err := p.machine().CallContext(ctx, callee, objects...)
+	if err != nil {
+		return Value{}, runError(err)
+	}
+
+	return result(returned)
+}
+
+// machine returns a VM that runs the program with its builtins, streams, limits and
+// globals
+func (p *Program) machine() *vm.VM {
+	machine := vm.NewWithGlobalsStore(p.bytecode, p.globals)
+	machine.SetBuiltins(p.builtins)
+	machine.SetIO(p.io)
+	machine.SetLimits(vm.Limits{MaxInstructions: p.limits.MaxSteps, MaxMemory: p.limits.MaxMemory})
+
+	return machine
+}
+
+// runError converts an error the VM stopped with to the error returned to the host
+func runError(err error) error {
+	if errors.Is(err, ErrExecutionLimit) {
+		return err
+	}
+
+	return &RuntimeError{Message: err.Error()}
+}
+
+// GetGlobal returns the value of the global named name. It returns an error if the
+// program has no such global.
+func (p *Program) GetGlobal(name string) (Value, error) {
+	index, err := p.globalIndex(name)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return newValue(p.globals[index]), nil
+}
+
+// SetGlobal sets the global named name to value, converting it the way the results of
+// registered functions are converted. The global must be defined by the program or
+// named when it was compiled.
+func (p *Program) SetGlobal(name string, value interface{}) error {
+	index, err := p.globalIndex(name)
+	if err != nil {
+		return err
+	}
+
+	obj, err := toObject(reflect.ValueOf(&value).Elem(), nil)
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+
+	p.globals[index] = nullIfNil(obj)
+	return nil
+}
+
+func (p *Program) globalIndex(name string) (int, error) {
+	symbol, ok := p.symbols.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return 0, fmt.Errorf("undefined global %s", name)
+	}
+
+	return symbol.Index, nil
+}
+
+// Run compiles source in the default sandbox and runs it with the VM, holding it to
+// limits
+func Run(ctx context.Context, source string, limits Limits) (Value, error) {
+	return defaultSandbox.Run(ctx, source, limits)
+}
+
+// Run compiles source in the sandbox and runs it with the VM, holding it to limits
+func (s *Sandbox) Run(ctx context.Context, source string, limits Limits) (Value, error) {
+	program, err := s.Compile(source)
+	if err != nil {
+		return Value{}, err
+	}
+	program.SetLimits(limits)
+
+	return program.Run(ctx)
+}
+
+// Eval evaluates source in the default sandbox with the tree-walking interpreter,
+// holding it to limits, and returns the value of its last statement. It is slower than
+// Run but starts faster, since nothing is compiled.
+func Eval(ctx context.Context, source string, limits Limits) (Value, error) {
+	return defaultSandbox.Eval(ctx, source, limits)
+}
+
+// Eval evaluates source in the sandbox with the tree-walking interpreter, like the
+// package-level Eval. A panic during the evaluation is reported as a RuntimeError, as the
+// VM reports one.
+func (s *Sandbox) Eval(ctx context.Context, source string, limits Limits) (value Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = Value{}, &RuntimeError{Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+
+	program, err := parse(source)
+	if err != nil {
+		return Value{}, err
+	}
+	optimizer.Fold(program)
+
+	env := object.NewEnvironment()
+	env.SetBuiltins(s.builtins)
+	env.SetIO(s.io)
+	evaluated, err := evaluator.EvalContext(ctx, program, env, evaluator.Limits{
+		MaxSteps:  limits.MaxSteps,
+		MaxMemory: limits.MaxMemory,
+	})
+	if err != nil {
+		return Value{}, err
+	}
+
+	return result(evaluated)
+}
+
+// result converts the result of a program to a Value. The interpreter leaves the error
+// object that stopped a program as its result.
+func result(obj object.Object) (Value, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return Value{}, &RuntimeError{Message: errObj.Message}
+	}
+
+	return newValue(obj), nil
+}
+
+// endsInExpression reports whether the last statement of program is an expression
+// statement
+func endsInExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// parse parses source and removes the code that can never run
+func parse(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &SyntaxError{Errors: p.Errors()}
+	}
+	optimizer.EliminateDeadCode(program)
+
+	return program, nil
+}
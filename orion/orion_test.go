@@ -0,0 +1,382 @@
+package orion_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JosueMolinaMorales/orionlang/orion"
+)
+
+type engine struct {
+	name string
+	run  func(ctx context.Context, source string, limits orion.Limits) (orion.Value, error)
+}
+
+var engines = []engine{
+	{"vm", orion.Run},
+	{"evaluator", orion.Eval},
+}
+
+func TestResults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2", int64(3)},
+		{`"orion" + "lang"`, "orionlang"},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"let x = 1;", nil},
+		{"[1, [true, \"a\"]]", []interface{}{int64(1), []interface{}{true, "a"}}},
+		{`{"a": 1, 2: [3], [4]: 5}`, map[interface{}]interface{}{"a": int64(1), int64(2): []interface{}{int64(3)}, "[4]": int64(5)}},
+		{"struct Point { x, y }; Point(1, 2)", map[string]interface{}{"x": int64(1), "y": int64(2)}},
+		{"let double = fn(x) { x * 2 }; double(21)", int64(42)},
+		{"reduce(map([1, 2, 3], fn(x) { x * x }), 0, fn(a, b) { a + b })", int64(14)},
+	}
+
+	for _, e := range engines {
+		for _, tt := range tests {
+			result, err := e.run(context.Background(), tt.input, orion.Limits{})
+			if err != nil {
+				t.Fatalf("%s: error running %q: %s", e.name, tt.input, err)
+			}
+
+			if !reflect.DeepEqual(result.Interface(), tt.expected) {
+				t.Errorf("%s: wrong result for %q. want=%#v, got=%#v", e.name, tt.input, tt.expected, result.Interface())
+			}
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+	result, err := orion.Run(context.Background(), `{"name": "orion", "tags": ["a", "b"], "stars": 3}`, orion.Limits{})
+	if err != nil {
+		t.Fatalf("error running program: %s", err)
+	}
+
+	if result.Kind() != orion.HashKind {
+		t.Fatalf("wrong kind. want=%s, got=%s", orion.HashKind, result.Kind())
+	}
+
+	m, err := result.Map()
+	if err != nil {
+		t.Fatalf("error converting to map: %s", err)
+	}
+
+	name, err := m["name"].Str()
+	if err != nil || name != "orion" {
+		t.Errorf("wrong name. want=%q, got=%q (%v)", "orion", name, err)
+	}
+
+	stars, err := m["stars"].Int()
+	if err != nil || stars != 3 {
+		t.Errorf("wrong stars. want=%d, got=%d (%v)", 3, stars, err)
+	}
+
+	tags, err := m["tags"].Array()
+	if err != nil || len(tags) != 2 || tags[1].Inspect() != "b" {
+		t.Errorf("wrong tags. got=%v (%v)", tags, err)
+	}
+
+	_, err = m["name"].Int()
+	var typeErr *orion.TypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected a *orion.TypeError. got=%v", err)
+	}
+	if err.Error() != "value is string, not integer" {
+		t.Errorf("wrong error. want=%q, got=%q", "value is string, not integer", err)
+	}
+
+	if !m["missing"].IsNull() {
+		t.Errorf("missing key is not null. got=%s", m["missing"])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, e := range engines {
+		_, err := e.run(context.Background(), "let = 1", orion.Limits{})
+		var syntaxErr *orion.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected a *orion.SyntaxError. got=%v", e.name, err)
+		}
+
+		_, err = e.run(context.Background(), `len(1)`, orion.Limits{})
+		var runtimeErr *orion.RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: expected a *orion.RuntimeError. got=%v", e.name, err)
+		}
+		if runtimeErr.Message != "argument to `len` not supported, got INTEGER" {
+			t.Errorf("%s: wrong message. got=%q", e.name, runtimeErr.Message)
+		}
+
+		for _, input := range []string{"let f = fn() { f() }; f()", "let f = fn(x) { map([x], f) }; f(1); 1"} {
+			_, err = e.run(context.Background(), input, orion.Limits{MaxSteps: 1000})
+			if !errors.Is(err, orion.ErrExecutionLimit) {
+				t.Errorf("%s: error %v for %q does not match orion.ErrExecutionLimit", e.name, err, input)
+			}
+		}
+
+		for _, input := range []string{"let f = fn(a, b) { a }; f(1)", "map([1, 2], fn(a, b) { a })", "let f = fn() { 1 }; f(1)"} {
+			_, err = e.run(context.Background(), input, orion.Limits{})
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%s: expected a *orion.RuntimeError for %q. got=%v", e.name, input, err)
+			}
+			if !strings.HasPrefix(runtimeErr.Message, "wrong number of arguments: want=") {
+				t.Errorf("%s: wrong message for %q. got=%q", e.name, input, runtimeErr.Message)
+			}
+		}
+
+		_, err = e.run(context.Background(), "let f = fn(n) { 1 + f(n + 1) }; f(0)", orion.Limits{})
+		if err == nil {
+			t.Errorf("%s: expected an error for unbounded recursion", e.name)
+		}
+	}
+
+	_, err := orion.Eval(context.Background(), "let f = fn(n) { 1 + f(n + 1) }; f(0)", orion.Limits{})
+	if err == nil || err.Error() != "execution limit exceeded: more than 65535 nested function calls" {
+		t.Errorf("wrong error for unbounded recursion. got=%v", err)
+	}
+	if !errors.Is(err, orion.ErrExecutionLimit) {
+		t.Errorf("error %v does not match orion.ErrExecutionLimit", err)
+	}
+}
+
+func TestProgramRunsRepeatedly(t *testing.T) {
+	program, err := orion.Compile("let counter = [0]; let next = push(counter, 1); len(next)")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := program.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+
+		n, err := result.Int()
+		if err != nil || n != 2 {
+			t.Errorf("wrong result on run %d. want=2, got=%d (%v)", i, n, err)
+		}
+	}
+
+	program.SetLimits(orion.Limits{MaxSteps: 2})
+	_, err = program.Run(context.Background())
+	if !errors.Is(err, orion.ErrExecutionLimit) {
+		t.Errorf("error %v does not match orion.ErrExecutionLimit", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	program, err := orion.Compile(`let timeout = 30; let name = "svc" + suffix; let scaled = timeout * factor;`, "suffix", "factor")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	unset, err := program.GetGlobal("factor")
+	if err != nil || !unset.IsNull() {
+		t.Errorf("global set before running is not null. got=%s (%v)", unset, err)
+	}
+
+	for _, factor := range []int{2, 3} {
+		if err := program.SetGlobal("suffix", "-a"); err != nil {
+			t.Fatalf("error setting suffix: %s", err)
+		}
+		if err := program.SetGlobal("factor", factor); err != nil {
+			t.Fatalf("error setting factor: %s", err)
+		}
+
+		_, err = program.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+
+		expected := map[string]interface{}{
+			"timeout": int64(30),
+			"name":    "svc-a",
+			"scaled":  int64(30 * factor),
+		}
+		for name, want := range expected {
+			got, err := program.GetGlobal(name)
+			if err != nil {
+				t.Fatalf("error getting %s: %s", name, err)
+			}
+			if got.Interface() != want {
+				t.Errorf("wrong value for %s. want=%#v, got=%#v", name, want, got.Interface())
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"missing", 1, "undefined global missing"},
+		{"len", 1, "undefined global len"},
+		{"factor", 1.5, "global factor: unsupported type float64"},
+	}
+
+	for _, tt := range tests {
+		err := program.SetGlobal(tt.name, tt.value)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error setting %s. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+
+	_, err = program.GetGlobal("missing")
+	if err == nil || err.Error() != "undefined global missing" {
+		t.Errorf("wrong error getting missing. want=%q, got=%v", "undefined global missing", err)
+	}
+
+	_, err = orion.Compile("x", "not valid")
+	if err == nil || err.Error() != `invalid global name "not valid"` {
+		t.Errorf("wrong error compiling. want=%q, got=%v", `invalid global name "not valid"`, err)
+	}
+}
+
+func TestPreseededGlobals(t *testing.T) {
+	program, err := orion.Compile(`request.method + " " + request["path"] + " " + first(tags)`, "request", "tags")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	program.SetGlobal("request", map[string]string{"method": "GET", "path": "/"})
+	program.SetGlobal("tags", []string{"a", "b"})
+
+	result, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	if s, _ := result.Str(); s != "GET / a" {
+		t.Errorf("wrong result. want=%q, got=%q", "GET / a", s)
+	}
+
+	tags, _ := program.GetGlobal("tags")
+	program.SetGlobal("tags", tags)
+	if _, err := program.Run(context.Background()); err != nil {
+		t.Fatalf("run error after setting a Value: %s", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	program, err := orion.Compile(`
+		let add = fn(a, b) { a + b };
+		let scaled = fn(xs) { map(xs, fn(x) { x * factor }) };
+		let greet = fn(name) { puts("hello " + name); len(name) };
+		let loop = fn() { loop() };
+		fn(h) { h["x"] + 1 }`, "factor")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	// Functions are bound to their globals by running the program
+	_, err = program.Call(context.Background(), "add", 1, 2)
+	var runtimeErr *orion.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a *orion.RuntimeError before running. got=%v", err)
+	}
+
+	returned, err := program.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	program.SetGlobal("factor", 10)
+
+	tests := []struct {
+		fn       interface{}
+		args     []interface{}
+		expected interface{}
+	}{
+		{"add", []interface{}{1, 2}, int64(3)},
+		{"add", []interface{}{"orion", "lang"}, "orionlang"},
+		{"scaled", []interface{}{[]int{1, 2}}, []interface{}{int64(10), int64(20)}},
+		{returned, []interface{}{map[string]int{"x": 41}}, int64(42)},
+	}
+
+	for _, tt := range tests {
+		result, err := program.Call(context.Background(), tt.fn, tt.args...)
+		if err != nil {
+			t.Fatalf("error calling %v: %s", tt.fn, err)
+		}
+		if !reflect.DeepEqual(result.Interface(), tt.expected) {
+			t.Errorf("wrong result calling %v. want=%#v, got=%#v", tt.fn, tt.expected, result.Interface())
+		}
+	}
+
+	var out bytes.Buffer
+	program.SetIO(orion.IO{Stdout: &out})
+	result, err := program.Call(context.Background(), "greet", "orion")
+	if n, _ := result.Int(); err != nil || n != 5 {
+		t.Errorf("wrong result calling greet. want=5, got=%d (%v)", n, err)
+	}
+	if out.String() != "hello orion\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "hello orion\n", out.String())
+	}
+
+	errorTests := []struct {
+		fn       interface{}
+		args     []interface{}
+		expected string
+	}{
+		{"missing", nil, "undefined global missing"},
+		{1, nil, "fn must be the name of a global or a Value, got int"},
+		{"add", []interface{}{1}, "wrong number of arguments: want=2, got=1"},
+		{"add", []interface{}{1, true}, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"add", []interface{}{1, struct{}{}}, "argument 2: unsupported type struct {}"},
+		{"factor", nil, "calling non-function and non-built-in"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := program.Call(context.Background(), tt.fn, tt.args...)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error calling %v. want=%q, got=%v", tt.fn, tt.expected, err)
+		}
+	}
+
+	program.SetLimits(orion.Limits{MaxSteps: 1000})
+	_, err = program.Call(context.Background(), "loop")
+	if !errors.Is(err, orion.ErrExecutionLimit) {
+		t.Errorf("error %v does not match orion.ErrExecutionLimit", err)
+	}
+}
+
+func TestIO(t *testing.T) {
+	var sandboxOut, programOut bytes.Buffer
+	sandbox := orion.NewSandbox()
+	sandbox.SetIO(orion.IO{Stdout: &sandboxOut})
+
+	if _, err := sandbox.Eval(context.Background(), `puts("eval")`, orion.Limits{}); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	program, err := sandbox.Compile(`puts("run", 1)`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if _, err := program.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	// Each program can write somewhere else, such as the response to its own request
+	program.SetIO(orion.IO{Stdout: &programOut})
+	if _, err := program.Run(context.Background()); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	if sandboxOut.String() != "eval\nrun\n1\n" {
+		t.Errorf("wrong sandbox output. want=%q, got=%q", "eval\nrun\n1\n", sandboxOut.String())
+	}
+	if programOut.String() != "run\n1\n" {
+		t.Errorf("wrong program output. want=%q, got=%q", "run\n1\n", programOut.String())
+	}
+
+	program.SetIO(orion.IO{})
+	if _, err := program.Run(context.Background()); err != nil {
+		t.Fatalf("run error with discarded output: %s", err)
+	}
+}
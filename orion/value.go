@@ -0,0 +1,261 @@
+package orion
+
+import (
+	"fmt"
+
+	"github.com/JosueMolinaMorales/orionlang/internal/object"
+)
+
+// Kind is the type of a Value
+type Kind int
+
+const (
+	NullKind Kind = iota
+	IntKind
+	BoolKind
+	StringKind
+	ArrayKind
+	HashKind
+	StructKind
+	// FunctionKind covers functions, builtins and struct types, which have no Go
+	// counterpart
+	FunctionKind
+	// HostKind is a value wrapped by a HostType
+	HostKind
+)
+
+var kindNames = map[Kind]string{
+	NullKind:     "null",
+	IntKind:      "integer",
+	BoolKind:     "boolean",
+	StringKind:   "string",
+	ArrayKind:    "array",
+	HashKind:     "hash",
+	StructKind:   "struct",
+	FunctionKind: "function",
+	HostKind:     "host",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// TypeError is returned when a Value is converted to a type that does not match its Kind
+type TypeError struct {
+	Want Kind
+	Got  Kind
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("value is %s, not %s", e.Got, e.Want)
+}
+
+// Value is a value produced by an OrionLang program. The zero Value is null.
+type Value struct {
+	obj object.Object
+}
+
+// Pair is a key and the value stored under it in a hash
+type Pair struct {
+	Key   Value
+	Value Value
+}
+
+func newValue(obj object.Object) Value {
+	if _, ok := obj.(*object.Null); ok {
+		return Value{}
+	}
+
+	return Value{obj: obj}
+}
+
+// Kind returns the type of v
+func (v Value) Kind() Kind {
+	switch v.obj.(type) {
+	case nil:
+		return NullKind
+	case *object.Integer:
+		return IntKind
+	case *object.Boolean:
+		return BoolKind
+	case *object.String:
+		return StringKind
+	case *object.Array:
+		return ArrayKind
+	case *object.Hash:
+		return HashKind
+	case *object.Struct:
+		return StructKind
+	case *object.HostObject:
+		return HostKind
+	default:
+		return FunctionKind
+	}
+}
+
+// IsNull reports whether v is null
+func (v Value) IsNull() bool {
+	return v.obj == nil
+}
+
+// Int returns v as an integer
+func (v Value) Int() (int64, error) {
+	i, ok := v.obj.(*object.Integer)
+	if !ok {
+		return 0, v.typeError(IntKind)
+	}
+
+	return i.Value, nil
+}
+
+// Bool returns v as a boolean
+func (v Value) Bool() (bool, error) {
+	b, ok := v.obj.(*object.Boolean)
+	if !ok {
+		return false, v.typeError(BoolKind)
+	}
+
+	return b.Value, nil
+}
+
+// Str returns v as a string. Use String or Inspect for the text of a value of any kind.
+func (v Value) Str() (string, error) {
+	s, ok := v.obj.(*object.String)
+	if !ok {
+		return "", v.typeError(StringKind)
+	}
+
+	return s.Value, nil
+}
+
+// Array returns the elements of v as an array
+func (v Value) Array() ([]Value, error) {
+	a, ok := v.obj.(*object.Array)
+	if !ok {
+		return nil, v.typeError(ArrayKind)
+	}
+
+	elements := make([]Value, len(a.Elements))
+	for i, e := range a.Elements {
+		elements[i] = newValue(e)
+	}
+
+	return elements, nil
+}
+
+// Pairs returns the pairs of v as a hash, in the order their keys were first inserted
+func (v Value) Pairs() ([]Pair, error) {
+	h, ok := v.obj.(*object.Hash)
+	if !ok {
+		return nil, v.typeError(HashKind)
+	}
+
+	pairs := make([]Pair, h.Len())
+	for i, pair := range h.OrderedPairs() {
+		pairs[i] = Pair{Key: newValue(pair.Key), Value: newValue(pair.Value)}
+	}
+
+	return pairs, nil
+}
+
+// Map returns v as a hash whose keys are all strings. Use Pairs for hashes with other
+// keys.
+func (v Value) Map() (map[string]Value, error) {
+	pairs, err := v.Pairs()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]Value, len(pairs))
+	for _, pair := range pairs {
+		key, err := pair.Key.Str()
+		if err != nil {
+			return nil, fmt.Errorf("hash key %s: %w", pair.Key.Inspect(), err)
+		}
+		m[key] = pair.Value
+	}
+
+	return m, nil
+}
+
+// Fields returns the fields of v as a struct, by name
+func (v Value) Fields() (map[string]Value, error) {
+	s, ok := v.obj.(*object.Struct)
+	if !ok {
+		return nil, v.typeError(StructKind)
+	}
+
+	fields := make(map[string]Value, len(s.Fields))
+	for i, name := range s.Def.Fields {
+		fields[name] = newValue(s.Fields[i])
+	}
+
+	return fields, nil
+}
+
+// Interface returns v as a plain Go value: nil, int64, bool, string, []interface{} for
+// arrays, map[interface{}]interface{} for hashes and map[string]interface{} for structs.
+// Hash keys that are arrays, hashes or host values may not be comparable in Go and are
+// replaced by the string Inspect returns for them. Values of a HostType are returned as
+// the Go value they wrap, and functions as the string Inspect returns.
+func (v Value) Interface() interface{} {
+	switch obj := v.obj.(type) {
+	case nil:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			elements[i] = newValue(e).Interface()
+		}
+		return elements
+	case *object.Hash:
+		m := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.OrderedPairs() {
+			key := newValue(pair.Key)
+			switch key.Kind() {
+			case ArrayKind, HashKind, HostKind:
+				m[key.Inspect()] = newValue(pair.Value).Interface()
+			default:
+				m[key.Interface()] = newValue(pair.Value).Interface()
+			}
+		}
+		return m
+	case *object.Struct:
+		fields := make(map[string]interface{}, len(obj.Fields))
+		for i, name := range obj.Def.Fields {
+			fields[name] = newValue(obj.Fields[i]).Interface()
+		}
+		return fields
+	case *object.HostObject:
+		return obj.Value
+	default:
+		return obj.Inspect()
+	}
+}
+
+// Inspect returns v the way the REPL prints it
+func (v Value) Inspect() string {
+	if v.obj == nil {
+		return "null"
+	}
+
+	return v.obj.Inspect()
+}
+
+func (v Value) String() string {
+	return v.Inspect()
+}
+
+func (v Value) typeError(want Kind) error {
+	return &TypeError{Want: want, Got: v.Kind()}
+}